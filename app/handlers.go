@@ -2,34 +2,105 @@ package app
 
 import (
 	"boilerplate/controllers"
+	"boilerplate/models"
 	u "boilerplate/utils"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
+// defaultRequestTimeout is the deadline of a request when its route group has no timeout configured
+const defaultRequestTimeout = time.Second * 30
+
+// registerHooks registers the models hooks of the handlers once, even when LoadRoutes is called again
+var registerHooks sync.Once
+
 // LoadRoutes is a function to create and return a new *mux.Router and your routes.
 func LoadRoutes() *mux.Router {
 	router := mux.NewRouter()
+	router.Use(u.RequireJSONMiddleware)
+
+	// Request deadlines per route group
+	authTimeout := u.TimeoutMiddleware(u.GetEnvDuration("auth_request_timeout", defaultRequestTimeout))
+	userTimeout := u.TimeoutMiddleware(u.GetEnvDuration("user_request_timeout", defaultRequestTimeout))
+	creditCardTimeout := u.TimeoutMiddleware(u.GetEnvDuration("creditcard_request_timeout", defaultRequestTimeout))
+	adminTimeout := u.TimeoutMiddleware(u.GetEnvDuration("admin_request_timeout", defaultRequestTimeout))
 
 	// Auth handlers
-	router.HandleFunc("/api/login", controllers.Signin).Methods("POST")
-	router.HandleFunc("/api/refresh", controllers.Refresh).Methods("POST")
+	router.Handle("/api/login", authTimeout(http.HandlerFunc(controllers.Signin))).Methods("POST")
+	router.Handle("/api/refresh", authTimeout(http.HandlerFunc(controllers.Refresh))).Methods("POST")
+	router.Handle("/api/validate-password", authTimeout(http.HandlerFunc(controllers.ValidatePassword))).Methods("POST")
+	router.Handle("/api/token-info", authTimeout(u.IsAuthorizedMiddleware(http.HandlerFunc(controllers.TokenInfo)))).Methods("GET")
 
 	// User handlers
-	router.HandleFunc("/api/users", controllers.CreateUser).Methods("POST")
-	router.Handle("/api/users", u.IsAuthorizedMiddleware(http.HandlerFunc(controllers.GetAllUsers))).Methods("GET")
-	router.Handle("/api/users/{id}", u.IsAuthorizedMiddleware(http.HandlerFunc(controllers.GetUserByID))).Methods("GET")
-	router.Handle("/api/users", u.IsAuthorizedMiddleware(http.HandlerFunc(controllers.UpdateUserByID))).Methods("PUT")
-	router.Handle("/api/users/{id}", u.IsAuthorizedMiddleware(http.HandlerFunc(controllers.DeleteUserByID))).Methods("DELETE")
-	router.Handle("/api/users/{id}/creditcards", u.IsAuthorizedMiddleware(http.HandlerFunc(controllers.GetAllCreditCardsByUserID))).Methods("GET")
+	router.Handle("/api/users", userTimeout(http.HandlerFunc(controllers.CreateUser))).Methods("POST")
+	router.Handle("/api/users", userTimeout(u.IsAuthorizedMiddleware(http.HandlerFunc(controllers.GetAllUsers)))).Methods("GET")
+	// Export streams its response, so it is not buffered by a timeout
+	router.Handle("/api/users/export", u.IsAuthorizedMiddleware(u.IsAdminMiddleware(http.HandlerFunc(controllers.ExportUsers)))).Methods("GET")
+	router.Handle("/api/users/search", userTimeout(u.IsAuthorizedMiddleware(http.HandlerFunc(controllers.SearchUsers)))).Methods("GET")
+	router.Handle("/api/users/recent", userTimeout(u.IsAuthorizedMiddleware(u.IsAdminMiddleware(http.HandlerFunc(controllers.GetRecentUsers))))).Methods("GET")
+	router.Handle("/api/users/signups", userTimeout(u.IsAuthorizedMiddleware(http.HandlerFunc(controllers.GetSignupsOverTime)))).Methods("GET")
+	router.Handle("/api/users/{id}", userTimeout(u.IsAuthorizedMiddleware(http.HandlerFunc(controllers.GetUserByID)))).Methods("GET")
+	router.Handle("/api/users", userTimeout(u.IsAuthorizedMiddleware(http.HandlerFunc(controllers.UpdateUserByID)))).Methods("PUT")
+	router.Handle("/api/users/{id}", userTimeout(u.IsAuthorizedMiddleware(http.HandlerFunc(controllers.PatchUserByID)))).Methods("PATCH")
+	router.Handle("/api/users/{id}", userTimeout(u.IsAuthorizedMiddleware(http.HandlerFunc(controllers.DeleteUserByID)))).Methods("DELETE")
+	router.Handle("/api/users/{id}/approve", userTimeout(u.IsAuthorizedMiddleware(u.IsAdminMiddleware(http.HandlerFunc(controllers.ApproveUserByID))))).Methods("POST")
+	router.Handle("/api/users/{id}/creditcards", userTimeout(u.IsAuthorizedMiddleware(http.HandlerFunc(controllers.GetAllCreditCardsByUserID)))).Methods("GET")
 
 	// Credit Card handlers
-	router.Handle("/api/creditcards", u.IsAuthorizedMiddleware(http.HandlerFunc(controllers.CreateCreditCard))).Methods("POST")
-	router.Handle("/api/creditcards", u.IsAuthorizedMiddleware(http.HandlerFunc(controllers.GetAllCreditCards))).Methods("GET")
-	router.Handle("/api/creditcards/{id}", u.IsAuthorizedMiddleware(http.HandlerFunc(controllers.GetCreditCardByID))).Methods("GET")
-	router.Handle("/api/creditcards", u.IsAuthorizedMiddleware(http.HandlerFunc(controllers.UpdateCreditCardByID))).Methods("PUT")
-	router.Handle("/api/creditcards/{id}", u.IsAuthorizedMiddleware(http.HandlerFunc(controllers.DeleteCreditCardByID))).Methods("DELETE")
+	router.Handle("/api/creditcards", creditCardTimeout(u.IsAuthorizedMiddleware(http.HandlerFunc(controllers.CreateCreditCard)))).Methods("POST")
+	router.Handle("/api/creditcards", creditCardTimeout(u.IsAuthorizedMiddleware(http.HandlerFunc(controllers.GetAllCreditCards)))).Methods("GET")
+	router.Handle("/api/creditcards/{id}", creditCardTimeout(u.IsAuthorizedMiddleware(http.HandlerFunc(controllers.GetCreditCardByID)))).Methods("GET")
+	router.Handle("/api/creditcards", creditCardTimeout(u.IsAuthorizedMiddleware(http.HandlerFunc(controllers.UpdateCreditCardByID)))).Methods("PUT")
+	router.Handle("/api/creditcards/{id}", creditCardTimeout(u.IsAuthorizedMiddleware(http.HandlerFunc(controllers.DeleteCreditCardByID)))).Methods("DELETE")
+
+	// Admin handlers
+	router.Handle("/api/admin/keys/rotate", adminTimeout(u.IsAuthorizedMiddleware(u.IsAdminMiddleware(http.HandlerFunc(controllers.RotateKeys))))).Methods("POST")
+	router.Handle("/api/admin/users/{id}/email", adminTimeout(u.IsAuthorizedMiddleware(u.IsAdminMiddleware(http.HandlerFunc(controllers.AdminChangeUserEmail))))).Methods("PUT")
+	router.Handle("/api/admin/users/{id}/restore", adminTimeout(u.IsAuthorizedMiddleware(u.IsAdminMiddleware(http.HandlerFunc(controllers.RestoreUserByID))))).Methods("POST")
+	// Events are streamed for as long as the client is connected, so they are not buffered by a timeout
+	router.Handle("/api/admin/events/users", u.IsAuthorizedMiddleware(u.IsAdminMiddleware(http.HandlerFunc(controllers.StreamUserEvents)))).Methods("GET")
+	registerHooks.Do(func() {
+		models.AfterCreate = append(models.AfterCreate, controllers.PublishUserCreated)
+	})
+
+	// Unknown routes and methods respond with the same JSON envelope as the handlers
+	router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u.Respond(w, http.StatusNotFound, u.NewResponse(true, "not found route", nil))
+	})
+	router.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", strings.Join(allowedMethods(router, r), ", "))
+		u.Respond(w, http.StatusMethodNotAllowed, u.NewResponse(true, "method not allowed", nil))
+	})
 
 	return router
 }
+
+// allowedMethods is a function to return the methods of all routes matching the request path
+func allowedMethods(router *mux.Router, r *http.Request) []string {
+	methods := make([]string, 0)
+	seen := make(map[string]bool)
+
+	router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		routeMethods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+
+		for _, method := range routeMethods {
+			methodRequest := r.Clone(r.Context())
+			methodRequest.Method = method
+			if !seen[method] && route.Match(methodRequest, &mux.RouteMatch{}) {
+				seen[method] = true
+				methods = append(methods, method)
+			}
+		}
+
+		return nil
+	})
+
+	return methods
+}
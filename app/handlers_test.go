@@ -0,0 +1,186 @@
+package app
+
+import (
+	"boilerplate/models"
+	"boilerplate/models/schema"
+	u "boilerplate/utils"
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// authHeader is a function to return the Authorization header of an auth token with role
+func authHeader(t *testing.T, role string) string {
+	t.Helper()
+	t.Setenv("jwtKey", "test-key")
+
+	token := u.GenerateNewJwt(u.Claims{
+		Email:          "admin@example.com",
+		Role:           role,
+		StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(time.Hour).Unix()},
+	}, "signin1")
+	if token == "" {
+		t.Fatal("expected a signed token")
+	}
+
+	return "Bearer " + token
+}
+
+// createUser is a function to run the after create hooks like models.NewUser does after inserting user
+func createUser(t *testing.T, user *schema.User) {
+	t.Helper()
+
+	for _, hook := range models.AfterCreate {
+		if err := hook(context.Background(), user); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestStreamUserEvents(t *testing.T) {
+	// Loading routes again must not register the hooks again
+	LoadRoutes()
+	server := httptest.NewServer(LoadRoutes())
+	defer server.Close()
+
+	t.Run("requires admin", func(t *testing.T) {
+		r, _ := http.NewRequest(http.MethodGet, server.URL+"/api/admin/events/users", nil)
+		r.Header.Set("Authorization", authHeader(t, "user"))
+
+		response, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+
+		if response.StatusCode != http.StatusForbidden {
+			t.Errorf("expected status %d, got %d", http.StatusForbidden, response.StatusCode)
+		}
+	})
+
+	t.Run("receives created users once", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		r, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/api/admin/events/users", nil)
+		r.Header.Set("Authorization", authHeader(t, "admin"))
+
+		// The client is subscribed once the response headers are received
+		response, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("expected status %d, got %d", http.StatusOK, response.StatusCode)
+		}
+
+		events := make(chan string)
+		go func() {
+			defer close(events)
+			reader := bufio.NewReader(response.Body)
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if strings.HasPrefix(line, "data: ") {
+					events <- line
+				}
+			}
+		}()
+
+		nextEvent := func() string {
+			select {
+			case event := <-events:
+				return event
+			case <-time.After(2 * time.Second):
+				t.Fatal("timed out waiting for event")
+				return ""
+			}
+		}
+
+		createUser(t, &schema.User{ID: 1, Name: "First", Email: "first@example.com"})
+		createUser(t, &schema.User{ID: 2, Name: "Second", Email: "second@example.com"})
+
+		// A duplicated hook would send the first user twice
+		if event := nextEvent(); !strings.Contains(event, `"email":"first@example.com"`) {
+			t.Errorf("expected first user event, got %s", event)
+		}
+		if event := nextEvent(); !strings.Contains(event, `"email":"second@example.com"`) {
+			t.Errorf("expected second user event, got %s", event)
+		}
+	})
+}
+
+func TestUnmatchedRoutes(t *testing.T) {
+	router := LoadRoutes()
+
+	cases := []struct {
+		name     string
+		method   string
+		path     string
+		expected int
+		message  string
+		allow    string
+	}{
+		{"unknown path", http.MethodGet, "/api/unknown", http.StatusNotFound, "not found route", ""},
+		{"wrong method", http.MethodDelete, "/api/users", http.StatusMethodNotAllowed, "method not allowed", "POST, GET, PUT"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, httptest.NewRequest(c.method, c.path, nil))
+
+			if w.Code != c.expected {
+				t.Fatalf("expected status %d, got %d", c.expected, w.Code)
+			}
+			var response u.Response
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("expected JSON body, got %q: %v", w.Body.String(), err)
+			}
+			if !response.Error || response.Message != c.message {
+				t.Errorf("unexpected response %+v", response)
+			}
+			if allow := w.Header().Get("Allow"); allow != c.allow {
+				t.Errorf("expected Allow %q, got %q", c.allow, allow)
+			}
+		})
+	}
+}
+
+func TestAdminOnlyRoutes(t *testing.T) {
+	router := LoadRoutes()
+
+	routes := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodGet, "/api/users/export"},
+		{http.MethodGet, "/api/users/recent"},
+	}
+
+	for _, route := range routes {
+		t.Run(route.method+" "+route.path, func(t *testing.T) {
+			r := httptest.NewRequest(route.method, route.path, nil)
+			r.Header.Set("Authorization", authHeader(t, "user"))
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, r)
+
+			if w.Code != http.StatusForbidden {
+				t.Errorf("expected status %d, got %d", http.StatusForbidden, w.Code)
+			}
+		})
+	}
+}
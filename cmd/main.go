@@ -5,8 +5,11 @@ package main
 import (
 	"boilerplate/app"
 	"boilerplate/database"
+	"boilerplate/models"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
 
 	"github.com/joho/godotenv"
 )
@@ -17,6 +20,8 @@ func main() {
 		log.Print("No .env file found")
 	}
 
+	models.SetLogger(slog.New(slog.NewTextHandler(os.Stderr, nil)))
+
 	router := app.LoadRoutes()
 	database.GenerateDatabaseURL()
 	database.OpenConnectionDatabase()
@@ -4,6 +4,7 @@ import (
 	"boilerplate/models"
 	u "boilerplate/utils"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
@@ -11,6 +12,17 @@ import (
 	"github.com/dgrijalva/jwt-go"
 )
 
+// Lifetime of auth and refresh tokens
+const (
+	tokenTTL        = time.Minute * 5
+	refreshTokenTTL = time.Hour * 8
+)
+
+// PasswordData is a struct that stores a password to be validated
+type PasswordData struct {
+	Password string `json:"password"`
+}
+
 // SigninData is a struct that stores auth data
 type SigninData struct {
 	Email    string `json:"email"`
@@ -32,17 +44,45 @@ func Signin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validates that the email exists and that the password matches
-	valid, err := models.Authenticate(signin.Email, signin.Password)
-	if err != nil || !valid {
-		u.Respond(w, http.StatusBadRequest, u.NewResponse(true, err.Error(), nil))
+	valid, err := models.Authenticate(r.Context(), signin.Email, signin.Password)
+	if errors.Is(err, models.ErrAccountLocked) {
+		u.Respond(w, http.StatusTooManyRequests, u.NewResponse(true, err.Error(), nil))
+		return
+	}
+
+	if errors.Is(err, models.ErrPendingApproval) {
+		u.Respond(w, http.StatusForbidden, u.NewResponse(true, err.Error(), nil))
+		return
+	}
+
+	if errors.Is(err, models.ErrUserNotFound) || errors.Is(err, models.ErrPasswordMismatch) {
+		u.Respond(w, http.StatusUnauthorized, u.NewResponse(true, err.Error(), nil))
+		return
+	}
+
+	if err != nil {
+		u.Respond(w, http.StatusInternalServerError, u.NewResponse(true, err.Error(), nil))
+		return
+	}
+
+	if !valid {
+		u.Respond(w, http.StatusUnauthorized, u.NewResponse(true, models.ErrPasswordMismatch.Error(), nil))
+		return
+	}
+
+	// Role of the user is carried by the auth token
+	user, err := models.GetUserByEmail(r.Context(), signin.Email)
+	if err != nil {
+		u.Respond(w, http.StatusInternalServerError, u.NewResponse(true, err.Error(), nil))
 		return
 	}
 
 	// Define token Claims
 	tokenClaims := u.Claims{
 		Email: signin.Email,
+		Role:  user.Role,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(time.Minute * 5).Unix(),
+			ExpiresAt: time.Now().Add(tokenTTL).Unix(),
 		},
 	}
 
@@ -50,7 +90,7 @@ func Signin(w http.ResponseWriter, r *http.Request) {
 	refreshTokenClaims := u.Claims{
 		Email: signin.Email,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(time.Hour * 8).Unix(),
+			ExpiresAt: time.Now().Add(refreshTokenTTL).Unix(),
 		},
 	}
 
@@ -69,9 +109,9 @@ func Signin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Update refresh user token into database
-	_, err = models.UpdateRefreshTokenByEmail(signin.Email, refreshTokenString)
+	_, err = models.UpdateRefreshTokenByEmail(r.Context(), signin.Email, refreshTokenString)
 	if err != nil {
-		u.Respond(w, http.StatusBadRequest, u.NewResponse(true, err.Error(), nil))
+		u.Respond(w, http.StatusInternalServerError, u.NewResponse(true, err.Error(), nil))
 		return
 	}
 
@@ -97,22 +137,23 @@ func Refresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := models.GetUserByToken(refreshData.RefreshToken)
-	if err != nil {
-		u.Respond(w, http.StatusBadRequest, u.NewResponse(true, err.Error(), nil))
+	user, err := models.GetUserByToken(r.Context(), refreshData.RefreshToken)
+	if errors.Is(err, models.ErrUserNotFound) {
+		u.Respond(w, http.StatusUnauthorized, u.NewResponse(true, "refresh token is invalid", nil))
 		return
 	}
 
-	if user == nil {
-		u.Respond(w, http.StatusOK, u.NewResponse(false, "refresh token is invalid", user))
+	if err != nil {
+		u.Respond(w, http.StatusInternalServerError, u.NewResponse(true, err.Error(), nil))
 		return
 	}
 
 	// Define token Claims
 	tokenClaims := u.Claims{
 		Email: user.Email,
+		Role:  user.Role,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(time.Minute * 5).Unix(),
+			ExpiresAt: time.Now().Add(tokenTTL).Unix(),
 		},
 	}
 
@@ -122,3 +163,61 @@ func Refresh(w http.ResponseWriter, r *http.Request) {
 		"token": tokenString,
 	}))
 }
+
+// RotateKeys is a function to replace the active auth and refresh token signing keys.
+// Old keys keep verifying tokens already issued until they expire. Keys are kept only in this process, see
+// u.RotateSigningKey
+func RotateKeys(w http.ResponseWriter, r *http.Request) {
+	kid, err := u.RotateSigningKey("signin1", tokenTTL)
+	if err != nil {
+		u.Respond(w, http.StatusInternalServerError, u.NewResponse(true, err.Error(), nil))
+		return
+	}
+
+	refreshKid, err := u.RotateSigningKey("signin2", refreshTokenTTL)
+	if err != nil {
+		u.Respond(w, http.StatusInternalServerError, u.NewResponse(true, err.Error(), nil))
+		return
+	}
+
+	u.Respond(w, http.StatusOK, u.NewResponse(false, "success", map[string]string{
+		"kid":         kid,
+		"refresh_kid": refreshKid,
+	}))
+}
+
+// ValidatePassword is a function to check a candidate password against the password policy without storing it
+func ValidatePassword(w http.ResponseWriter, r *http.Request) {
+	passwordData := &PasswordData{}
+
+	err := json.NewDecoder(r.Body).Decode(passwordData)
+	if err != nil {
+		fmt.Println(err)
+		u.Respond(w, http.StatusBadRequest, u.NewResponse(true, "malformed json", nil))
+		return
+	}
+
+	if err := models.ValidatePassword(passwordData.Password); err != nil {
+		respondUserError(w, err)
+		return
+	}
+
+	u.Respond(w, http.StatusOK, u.NewResponse(false, "success", nil))
+}
+
+// TokenInfo is a function to return when the auth token expires, so clients can refresh it in advance
+func TokenInfo(w http.ResponseWriter, r *http.Request) {
+	claims := u.ClaimsFromRequest(r)
+	expiresAt := time.Unix(claims.ExpiresAt, 0)
+
+	expiresIn := int64(time.Until(expiresAt).Seconds())
+	if expiresIn < 0 {
+		expiresIn = 0
+	}
+
+	u.Respond(w, http.StatusOK, u.NewResponse(false, "success", map[string]interface{}{
+		"expires_at":         expiresAt.UTC().Format(time.RFC3339),
+		"expires_in_seconds": expiresIn,
+		"jti":                claims.Id,
+	}))
+}
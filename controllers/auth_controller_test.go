@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"boilerplate/models"
+	u "boilerplate/utils"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestValidatePassword(t *testing.T) {
+	cases := []struct {
+		name     string
+		body     string
+		expected int
+	}{
+		{"compliant", `{"password":"123456"}`, http.StatusOK},
+		{"too short", `{"password":"12345"}`, http.StatusUnprocessableEntity},
+		{"empty", `{"password":""}`, http.StatusUnprocessableEntity},
+		{"malformed", `{"password":`, http.StatusBadRequest},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/auth/validate-password", strings.NewReader(c.body))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			ValidatePassword(w, r)
+
+			if w.Code != c.expected {
+				t.Fatalf("expected status %d, got %d", c.expected, w.Code)
+			}
+			if c.expected == http.StatusUnprocessableEntity && !strings.Contains(w.Body.String(), `"field":"password"`) {
+				t.Errorf("expected password rule in details, got %s", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestTokenInfo(t *testing.T) {
+	t.Setenv("jwtKey", "test-key")
+	handler := u.IsAuthorizedMiddleware(http.HandlerFunc(TokenInfo))
+
+	requestInfo := func(t *testing.T, expiresAt time.Time) *httptest.ResponseRecorder {
+		t.Helper()
+
+		token := u.GenerateNewJwt(u.Claims{
+			Email:          "user@example.com",
+			StandardClaims: jwt.StandardClaims{ExpiresAt: expiresAt.Unix()},
+		}, "signin1")
+		if token == "" {
+			t.Fatal("expected a signed token")
+		}
+
+		r := httptest.NewRequest(http.MethodGet, "/api/token-info", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		return w
+	}
+
+	t.Run("fresh token", func(t *testing.T) {
+		w := requestInfo(t, time.Now().Add(10*time.Minute))
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		data, _ := decodeResponse(t, w).Data.(map[string]interface{})
+		expiresIn, _ := data["expires_in_seconds"].(float64)
+		if expiresIn < 590 || expiresIn > 600 {
+			t.Errorf("expected about 600 remaining seconds, got %v", data["expires_in_seconds"])
+		}
+		if jti, _ := data["jti"].(string); jti == "" {
+			t.Error("expected the token jti")
+		}
+	})
+
+	t.Run("expired token", func(t *testing.T) {
+		w := requestInfo(t, time.Now().Add(-time.Minute))
+
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("expected status %d, got %d", http.StatusUnauthorized, w.Code)
+		}
+	})
+}
+
+func TestSigninAuthenticateErrors(t *testing.T) {
+	cases := []struct {
+		name     string
+		err      error
+		expected int
+	}{
+		{"not found", models.ErrUserNotFound, http.StatusUnauthorized},
+		{"password mismatch", models.ErrPasswordMismatch, http.StatusUnauthorized},
+		{"pending approval", models.ErrPendingApproval, http.StatusForbidden},
+		{"locked", models.ErrAccountLocked, http.StatusTooManyRequests},
+		{"database failure", errors.New("pq: connection refused"), http.StatusInternalServerError},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			previous := models.Authenticate
+			models.Authenticate = func(ctx context.Context, email, password string) (bool, error) {
+				return false, c.err
+			}
+			t.Cleanup(func() { models.Authenticate = previous })
+
+			r := httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(`{"email":"user@example.com","password":"secret123"}`))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			Signin(w, r)
+
+			if w.Code != c.expected {
+				t.Errorf("expected status %d, got %d", c.expected, w.Code)
+			}
+		})
+	}
+}
@@ -56,7 +56,7 @@ var CreateCreditCard = func(w http.ResponseWriter, r *http.Request) {
 	}
 
 	creditCardModel := jCreditCard.ConvertToModel()
-	creditCardCreated, err := models.NewCreditCard(creditCardModel)
+	creditCardCreated, err := models.NewCreditCard(r.Context(), creditCardModel)
 	if err != nil {
 		u.Respond(w, http.StatusBadRequest, u.NewResponse(true, err.Error(), nil))
 		return
@@ -66,7 +66,7 @@ var CreateCreditCard = func(w http.ResponseWriter, r *http.Request) {
 }
 
 var GetAllCreditCards = func(w http.ResponseWriter, r *http.Request) {
-	allCreditCards, err := models.GetAllCreditCards()
+	allCreditCards, err := models.GetAllCreditCards(r.Context())
 	if err != nil {
 		u.Respond(w, http.StatusBadRequest, u.NewResponse(true, err.Error(), nil))
 		return
@@ -85,7 +85,7 @@ var GetCreditCardByID = func(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	creditCardByID, _ := strconv.Atoi(params["id"])
 
-	creditCard, err := models.GetCreditCardByID(creditCardByID)
+	creditCard, err := models.GetCreditCardByID(r.Context(), creditCardByID)
 	if err != nil {
 		u.Respond(w, http.StatusBadRequest, u.NewResponse(true, err.Error(), nil))
 		return
@@ -110,7 +110,7 @@ var UpdateCreditCardByID = func(w http.ResponseWriter, r *http.Request) {
 	}
 
 	creditCardModel := jCreditCard.ConvertToModel()
-	rowsAff, err := models.UpdateCreditCard(creditCardModel)
+	rowsAff, err := models.UpdateCreditCard(r.Context(), creditCardModel)
 	if err != nil {
 		u.Respond(w, http.StatusBadRequest, u.NewResponse(true, err.Error(), nil))
 		return
@@ -123,7 +123,7 @@ var DeleteCreditCardByID = func(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	creditCardID, _ := strconv.Atoi(params["id"])
 
-	rowsAff, err := models.DeleteCreditCardByID(creditCardID)
+	rowsAff, err := models.DeleteCreditCardByID(r.Context(), creditCardID)
 	if err != nil {
 		u.Respond(w, http.StatusBadRequest, u.NewResponse(true, err.Error(), nil))
 	}
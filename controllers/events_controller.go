@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	"boilerplate/models/schema"
+	u "boilerplate/utils"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// sseHeartbeatInterval is how often a comment is sent to keep idle SSE connections alive
+const sseHeartbeatInterval = time.Second * 15
+
+// userCreatedSubscribers stores the channel of each client streaming user created events
+var (
+	userCreatedSubscribersMut sync.Mutex
+	userCreatedSubscribers    = make(map[chan JSONUser]bool)
+)
+
+// PublishUserCreated is a models.AfterCreate hook to send a created user to all clients streaming user events.
+// Clients that are not keeping up miss the event instead of blocking the user creation
+func PublishUserCreated(ctx context.Context, user *schema.User) error {
+	event := NewJSONUser(*user)
+
+	userCreatedSubscribersMut.Lock()
+	defer userCreatedSubscribersMut.Unlock()
+
+	for subscriber := range userCreatedSubscribers {
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+
+	return nil
+}
+
+var StreamUserEvents = func(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		u.Respond(w, http.StatusInternalServerError, u.NewResponse(true, "streaming is not supported", nil))
+		return
+	}
+
+	// Subscribe to user created events until the client disconnects
+	events := make(chan JSONUser, 16)
+	userCreatedSubscribersMut.Lock()
+	userCreatedSubscribers[events] = true
+	userCreatedSubscribersMut.Unlock()
+
+	defer func() {
+		userCreatedSubscribersMut.Lock()
+		delete(userCreatedSubscribers, events)
+		userCreatedSubscribersMut.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+		case event := <-events:
+			data, err := json.Marshal(event)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			fmt.Fprintf(w, "event: user.created\ndata: %s\n\n", data)
+			flusher.Flush()
+		}
+	}
+}
@@ -5,9 +5,12 @@ import (
 	"boilerplate/models/schema"
 	u "boilerplate/utils"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"mime"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -20,6 +23,19 @@ type JSONUser struct {
 	Password string `json:"password"`
 }
 
+// JSONPatchOperation is a struct to receive a single JSON Patch (RFC 6902) operation on API
+type JSONPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// patchableUserFields maps the JSON Patch paths that can be patched to the user field they change
+var patchableUserFields = map[string]func(user *schema.User) *string{
+	"/name":  func(user *schema.User) *string { return &user.Name },
+	"/email": func(user *schema.User) *string { return &user.Email },
+}
+
 // ConvertToModel is a function to convert a received JSON from http and convert to model struct type
 func (jUser JSONUser) ConvertToModel() *schema.User {
 	userIDConverted, _ := strconv.Atoi(jUser.ID)
@@ -44,6 +60,84 @@ func NewJSONUser(user schema.User) JSONUser {
 	}
 }
 
+// badRequestErrors are the model errors caused by invalid arguments of the request
+var badRequestErrors = []error{
+	models.ErrImmutableField,
+	models.ErrNoChanges,
+	models.ErrColumnNotAllowed,
+	models.ErrInvalidSort,
+	models.ErrInvalidOrder,
+	models.ErrInvalidInterval,
+	models.ErrInvalidPeriod,
+	models.ErrInvalidLimit,
+}
+
+// respondUserError is a function to respond a model error, with field level details for validation errors.
+// Errors not caused by the request, like database failures, respond 500
+func respondUserError(w http.ResponseWriter, err error) {
+	var validationErrors models.ValidationErrors
+	if errors.As(err, &validationErrors) {
+		u.Respond(w, http.StatusUnprocessableEntity, u.NewResponse(true, models.ErrValidation.Error(), map[string]interface{}{
+			"details": validationErrors,
+		}))
+		return
+	}
+
+	if errors.Is(err, models.ErrUserNotFound) {
+		u.Respond(w, http.StatusNotFound, u.NewResponse(true, err.Error(), nil))
+		return
+	}
+
+	if errors.Is(err, models.ErrNotAdmin) {
+		u.Respond(w, http.StatusForbidden, u.NewResponse(true, err.Error(), nil))
+		return
+	}
+
+	if errors.Is(err, models.ErrUserAlreadyActive) {
+		u.Respond(w, http.StatusConflict, u.NewResponse(true, err.Error(), nil))
+		return
+	}
+
+	for _, badRequestErr := range badRequestErrors {
+		if errors.Is(err, badRequestErr) {
+			u.Respond(w, http.StatusBadRequest, u.NewResponse(true, err.Error(), nil))
+			return
+		}
+	}
+
+	u.Respond(w, http.StatusInternalServerError, u.NewResponse(true, err.Error(), nil))
+}
+
+// allowEmailChange is a function to check that the email of the user with id equal to userID is kept, or that the
+// change is made by the user itself, responding 403 and returning false when the change is not allowed
+func allowEmailChange(w http.ResponseWriter, r *http.Request, userID int, email string) bool {
+	unchanged, err := models.IsEmailOwnedBy(r.Context(), userID, email)
+	if err != nil {
+		respondUserError(w, err)
+		return false
+	}
+	if unchanged {
+		return true
+	}
+
+	// The auth token email must be the current email of the changed user
+	claims := u.ClaimsFromRequest(r)
+	owner := false
+	if claims != nil {
+		owner, err = models.IsEmailOwnedBy(r.Context(), userID, claims.Email)
+		if err != nil {
+			respondUserError(w, err)
+			return false
+		}
+	}
+	if !owner {
+		u.Respond(w, http.StatusForbidden, u.NewResponse(true, "only the user can change its own e-mail", nil))
+		return false
+	}
+
+	return true
+}
+
 var CreateUser = func(w http.ResponseWriter, r *http.Request) {
 	jUser := &JSONUser{}
 
@@ -55,9 +149,9 @@ var CreateUser = func(w http.ResponseWriter, r *http.Request) {
 	}
 
 	userModel := jUser.ConvertToModel()
-	userCreated, err := models.NewUser(userModel)
+	userCreated, err := models.NewUser(r.Context(), userModel)
 	if err != nil {
-		u.Respond(w, http.StatusBadRequest, u.NewResponse(true, err.Error(), nil))
+		respondUserError(w, err)
 		return
 	}
 
@@ -65,33 +159,94 @@ var CreateUser = func(w http.ResponseWriter, r *http.Request) {
 }
 
 var GetAllUsers = func(w http.ResponseWriter, r *http.Request) {
-	allUsers, err := models.GetAllUsers()
+	query := r.URL.Query()
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	offset, _ := strconv.Atoi(query.Get("offset"))
+
+	pageUsers, total, err := models.GetUsersPaginated(r.Context(), limit, offset, query.Get("sort"), query.Get("order"))
 	if err != nil {
-		u.Respond(w, http.StatusBadRequest, u.NewResponse(true, err.Error(), nil))
+		respondUserError(w, err)
+		return
+	}
+
+	pageJSONUsers := make([]JSONUser, 0)
+
+	for _, user := range pageUsers {
+		pageJSONUsers = append(pageJSONUsers, NewJSONUser(*user))
+	}
+
+	u.Respond(w, http.StatusOK, u.NewResponse(false, "success", map[string]interface{}{
+		"users": pageJSONUsers,
+		"total": total,
+	}))
+}
+
+var SearchUsers = func(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := models.UserFilter{
+		NameContains:  query.Get("name"),
+		EmailContains: query.Get("email"),
+	}
+	filter.Limit, _ = strconv.Atoi(query.Get("limit"))
+	filter.Offset, _ = strconv.Atoi(query.Get("offset"))
+
+	if value := query.Get("created_after"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			u.Respond(w, http.StatusBadRequest, u.NewResponse(true, "created_after must be a RFC3339 date", nil))
+			return
+		}
+		filter.CreatedAfter = &parsed
+	}
+
+	foundUsers, total, err := models.SearchUsers(r.Context(), filter)
+	if err != nil {
+		u.Respond(w, http.StatusInternalServerError, u.NewResponse(true, err.Error(), nil))
+		return
+	}
+
+	foundJSONUsers := make([]JSONUser, 0)
+
+	for _, user := range foundUsers {
+		foundJSONUsers = append(foundJSONUsers, NewJSONUser(*user))
+	}
+
+	u.Respond(w, http.StatusOK, u.NewResponse(false, "success", map[string]interface{}{
+		"users": foundJSONUsers,
+		"total": total,
+	}))
+}
+
+var GetRecentUsers = func(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	recentUsers, err := models.ListRecentUsers(r.Context(), limit)
+	if err != nil {
+		respondUserError(w, err)
 		return
 	}
 
-	allJSONUsers := make([]JSONUser, 0)
+	recentJSONUsers := make([]JSONUser, 0)
 
-	for _, user := range allUsers {
-		allJSONUsers = append(allJSONUsers, NewJSONUser(*user))
+	for _, user := range recentUsers {
+		recentJSONUsers = append(recentJSONUsers, NewJSONUser(*user))
 	}
 
-	u.Respond(w, http.StatusOK, u.NewResponse(false, "success", allJSONUsers))
+	u.Respond(w, http.StatusOK, u.NewResponse(false, "success", recentJSONUsers))
 }
 
 var GetUserByID = func(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	userID, _ := strconv.Atoi(params["id"])
 
-	user, err := models.GetUserByID(userID)
-	if err != nil {
-		u.Respond(w, http.StatusBadRequest, u.NewResponse(true, err.Error(), nil))
+	user, err := models.GetUserByID(r.Context(), userID)
+	if errors.Is(err, models.ErrUserNotFound) {
+		u.Respond(w, http.StatusNotFound, u.NewResponse(true, err.Error(), nil))
 		return
 	}
 
-	if user == nil {
-		u.Respond(w, http.StatusOK, u.NewResponse(false, "not found user", user))
+	if err != nil {
+		u.Respond(w, http.StatusInternalServerError, u.NewResponse(true, err.Error(), nil))
 		return
 	}
 
@@ -109,9 +264,81 @@ var UpdateUserByID = func(w http.ResponseWriter, r *http.Request) {
 	}
 
 	userModel := jUser.ConvertToModel()
-	rowsAff, err := models.UpdateUser(userModel)
+	if !allowEmailChange(w, r, userModel.ID, userModel.Email) {
+		return
+	}
+
+	rowsAff, err := models.UpdateUser(r.Context(), userModel)
 	if err != nil {
-		u.Respond(w, http.StatusBadRequest, u.NewResponse(true, err.Error(), nil))
+		respondUserError(w, err)
+		return
+	}
+
+	u.Respond(w, http.StatusOK, u.NewResponse(false, "success", rowsAff))
+}
+
+var PatchUserByID = func(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	userID, _ := strconv.Atoi(params["id"])
+
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json-patch+json" {
+		u.Respond(w, http.StatusUnsupportedMediaType, u.NewResponse(true, "content type must be application/json-patch+json", nil))
+		return
+	}
+
+	operations := make([]JSONPatchOperation, 0)
+	err := json.NewDecoder(r.Body).Decode(&operations)
+	if err != nil {
+		fmt.Println(err)
+		u.Respond(w, http.StatusBadRequest, u.NewResponse(true, "malformed json", nil))
+		return
+	}
+
+	user, err := models.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondUserError(w, err)
+		return
+	}
+
+	// Apply operations in order over the current user data
+	for _, operation := range operations {
+		field, ok := patchableUserFields[operation.Path]
+		if !ok {
+			u.Respond(w, http.StatusUnprocessableEntity, u.NewResponse(true, "path "+operation.Path+" cannot be patched", nil))
+			return
+		}
+
+		value, isString := operation.Value.(string)
+
+		switch operation.Op {
+		case "replace":
+			if !isString {
+				u.Respond(w, http.StatusUnprocessableEntity, u.NewResponse(true, "value of "+operation.Path+" must be a string", nil))
+				return
+			}
+			*field(user) = value
+		case "test":
+			if !isString || *field(user) != value {
+				u.Respond(w, http.StatusConflict, u.NewResponse(true, "test of "+operation.Path+" failed", nil))
+				return
+			}
+		case "remove":
+			// All patchable fields are required
+			u.Respond(w, http.StatusUnprocessableEntity, u.NewResponse(true, "path "+operation.Path+" is required and cannot be removed", nil))
+			return
+		default:
+			u.Respond(w, http.StatusUnprocessableEntity, u.NewResponse(true, "operation "+operation.Op+" is not supported", nil))
+			return
+		}
+	}
+
+	if !allowEmailChange(w, r, userID, user.Email) {
+		return
+	}
+
+	rowsAff, err := models.UpdateUser(r.Context(), user)
+	if err != nil {
+		respondUserError(w, err)
 		return
 	}
 
@@ -122,20 +349,130 @@ var DeleteUserByID = func(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	userID, _ := strconv.Atoi(params["id"])
 
-	rowsAff, err := models.DeleteUserByID(userID)
+	rowsAff, err := models.DeleteUserByID(r.Context(), userID)
 	if err != nil {
-		u.Respond(w, http.StatusBadRequest, u.NewResponse(true, err.Error(), nil))
+		respondUserError(w, err)
+		return
+	}
+
+	u.Respond(w, http.StatusOK, u.NewResponse(false, "success", rowsAff))
+}
+
+var RestoreUserByID = func(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	userID, _ := strconv.Atoi(params["id"])
+
+	rowsAff, err := models.RestoreUserByID(r.Context(), userID)
+	if err != nil {
+		respondUserError(w, err)
+		return
+	}
+
+	u.Respond(w, http.StatusOK, u.NewResponse(false, "success", rowsAff))
+}
+
+var ApproveUserByID = func(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	userID, _ := strconv.Atoi(params["id"])
+
+	// The admin approving is the user authenticated by the auth token
+	admin, err := models.GetUserByEmail(r.Context(), u.ClaimsFromRequest(r).Email)
+	if err != nil {
+		u.Respond(w, http.StatusUnauthorized, u.NewResponse(true, "not found admin", nil))
+		return
+	}
+
+	rowsAff, err := models.ApproveUser(r.Context(), admin.ID, userID)
+	if err != nil {
+		respondUserError(w, err)
 		return
 	}
 
 	u.Respond(w, http.StatusOK, u.NewResponse(false, "success", rowsAff))
 }
 
+var AdminChangeUserEmail = func(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	userID, _ := strconv.Atoi(params["id"])
+
+	jUser := &JSONUser{}
+	err := json.NewDecoder(r.Body).Decode(jUser)
+	if err != nil {
+		fmt.Println(err)
+		u.Respond(w, http.StatusBadRequest, u.NewResponse(true, "malformed json", nil))
+		return
+	}
+
+	// The admin changing the email is the user authenticated by the auth token
+	admin, err := models.GetUserByEmail(r.Context(), u.ClaimsFromRequest(r).Email)
+	if err != nil {
+		u.Respond(w, http.StatusUnauthorized, u.NewResponse(true, "not found admin", nil))
+		return
+	}
+
+	err = models.AdminChangeEmail(r.Context(), admin.ID, userID, jUser.Email)
+	if err != nil {
+		respondUserError(w, err)
+		return
+	}
+
+	u.Respond(w, http.StatusOK, u.NewResponse(false, "success", nil))
+}
+
+var ExportUsers = func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	// Response status was already sent, so errors can only be logged
+	if err := models.ExportUsersNDJSON(r.Context(), w); err != nil {
+		fmt.Println(err)
+	}
+}
+
+var GetSignupsOverTime = func(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	// Default period is the last 30 days grouped by day
+	to := time.Now()
+	from := to.AddDate(0, 0, -30)
+	interval := "day"
+
+	if value := query.Get("from"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			u.Respond(w, http.StatusBadRequest, u.NewResponse(true, "from must be a RFC3339 date", nil))
+			return
+		}
+		from = parsed
+	}
+
+	if value := query.Get("to"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			u.Respond(w, http.StatusBadRequest, u.NewResponse(true, "to must be a RFC3339 date", nil))
+			return
+		}
+		to = parsed
+	}
+
+	if value := query.Get("interval"); value != "" {
+		interval = value
+	}
+
+	buckets, err := models.SignupsOverTime(r.Context(), from, to, interval)
+	if err != nil {
+		respondUserError(w, err)
+		return
+	}
+
+	u.Respond(w, http.StatusOK, u.NewResponse(false, "success", buckets))
+}
+
 var GetAllCreditCardsByUserID = func(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	userID, _ := strconv.Atoi(params["id"])
 
-	creditCards, err := models.GetAllCreditCardsByUser(userID)
+	creditCards, err := models.GetAllCreditCardsByUser(r.Context(), userID)
 	if err != nil {
 		u.Respond(w, http.StatusBadRequest, u.NewResponse(true, err.Error(), nil))
 		return
@@ -0,0 +1,316 @@
+package controllers
+
+import (
+	"boilerplate/database"
+	"boilerplate/models"
+	"boilerplate/models/schema"
+	u "boilerplate/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+)
+
+// mockDatabase is a function to replace database.InstanceDB by a sqlmock database until the end of the test,
+// failing the test when an expected query was not executed
+func mockDatabase(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous := database.InstanceDB
+	database.InstanceDB = db
+	t.Cleanup(func() {
+		database.InstanceDB = previous
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+
+	return mock
+}
+
+// stubUserStore is a function to replace models.GetUserByID, models.IsEmailOwnedBy and models.UpdateUser by an in
+// memory user until the end of the test, returning the pointer of the user updated
+func stubUserStore(t *testing.T, stored schema.User) **schema.User {
+	t.Helper()
+
+	updated := new(*schema.User)
+	previousGet, previousOwned, previousUpdate := models.GetUserByID, models.IsEmailOwnedBy, models.UpdateUser
+	models.GetUserByID = func(ctx context.Context, userId int) (*schema.User, error) {
+		if userId != stored.ID {
+			return nil, models.ErrUserNotFound
+		}
+		user := stored
+		return &user, nil
+	}
+	models.IsEmailOwnedBy = func(ctx context.Context, userID int, email string) (bool, error) {
+		return userID == stored.ID && strings.EqualFold(strings.TrimSpace(email), stored.Email), nil
+	}
+	models.UpdateUser = func(ctx context.Context, userToUpdate *schema.User) (int64, error) {
+		*updated = userToUpdate
+		return 1, nil
+	}
+	t.Cleanup(func() {
+		models.GetUserByID, models.IsEmailOwnedBy, models.UpdateUser = previousGet, previousOwned, previousUpdate
+	})
+
+	return updated
+}
+
+// patchUser is a function to call PatchUserByID with the JSON Patch operations as body
+func patchUser(userID string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPatch, "/api/users/"+userID, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json-patch+json")
+	r = mux.SetURLVars(r, map[string]string{"id": userID})
+	w := httptest.NewRecorder()
+
+	PatchUserByID(w, r)
+
+	return w
+}
+
+// authorizedRequest is a function to call handler through IsAuthorizedMiddleware with an auth token of email
+func authorizedRequest(t *testing.T, handler http.HandlerFunc, r *http.Request, email string) *httptest.ResponseRecorder {
+	t.Helper()
+	t.Setenv("jwtKey", "test-key")
+
+	token := u.GenerateNewJwt(u.Claims{
+		Email:          email,
+		StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(time.Hour).Unix()},
+	}, "signin1")
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+
+	u.IsAuthorizedMiddleware(handler).ServeHTTP(w, r)
+
+	return w
+}
+
+// decodeResponse is a function to decode the JSON body of a recorded response
+func decodeResponse(t *testing.T, w *httptest.ResponseRecorder) u.Response {
+	t.Helper()
+
+	var response u.Response
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("expected JSON body, got %q: %v", w.Body.String(), err)
+	}
+
+	return response
+}
+
+func TestPatchUserByID(t *testing.T) {
+	stored := schema.User{ID: 1, Name: "User", Email: "user@example.com"}
+
+	t.Run("replace", func(t *testing.T) {
+		updated := stubUserStore(t, stored)
+
+		w := patchUser("1", `[{"op":"test","path":"/name","value":"User"},{"op":"replace","path":"/name","value":"New name"}]`)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		if *updated == nil || (*updated).Name != "New name" || (*updated).Email != stored.Email {
+			t.Errorf("expected only the name to be replaced, got %+v", *updated)
+		}
+	})
+
+	t.Run("replace password", func(t *testing.T) {
+		updated := stubUserStore(t, stored)
+
+		w := patchUser("1", `[{"op":"replace","path":"/password","value":"NewPassword1!"}]`)
+
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("expected status %d, got %d", http.StatusUnprocessableEntity, w.Code)
+		}
+		if response := decodeResponse(t, w); response.Message != "path /password cannot be patched" {
+			t.Errorf("unexpected message %q", response.Message)
+		}
+		if *updated != nil {
+			t.Error("expected user not to be updated")
+		}
+	})
+
+	t.Run("replace email", func(t *testing.T) {
+		cases := []struct {
+			name      string
+			authEmail string
+			expected  int
+		}{
+			{"by the user", "User@Example.com", http.StatusOK},
+			{"by another user", "other@example.com", http.StatusForbidden},
+		}
+
+		for _, c := range cases {
+			t.Run(c.name, func(t *testing.T) {
+				updated := stubUserStore(t, stored)
+
+				r := httptest.NewRequest(http.MethodPatch, "/api/users/1", strings.NewReader(`[{"op":"replace","path":"/email","value":"new@example.com"}]`))
+				r.Header.Set("Content-Type", "application/json-patch+json")
+				r = mux.SetURLVars(r, map[string]string{"id": "1"})
+				w := authorizedRequest(t, PatchUserByID, r, c.authEmail)
+
+				if w.Code != c.expected {
+					t.Fatalf("expected status %d, got %d: %s", c.expected, w.Code, w.Body.String())
+				}
+				if (c.expected == http.StatusOK) != (*updated != nil) {
+					t.Errorf("unexpected update %+v", *updated)
+				}
+			})
+		}
+	})
+
+	t.Run("failed test", func(t *testing.T) {
+		updated := stubUserStore(t, stored)
+
+		w := patchUser("1", `[{"op":"test","path":"/name","value":"Other"},{"op":"replace","path":"/name","value":"New name"}]`)
+
+		if w.Code != http.StatusConflict {
+			t.Fatalf("expected status %d, got %d", http.StatusConflict, w.Code)
+		}
+		if *updated != nil {
+			t.Error("expected user not to be updated")
+		}
+	})
+}
+
+func TestCreateUserValidationDetails(t *testing.T) {
+	mock := mockDatabase(t)
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	r := httptest.NewRequest(http.MethodPost, "/api/users", strings.NewReader(`{"name":"","email":"not an e-mail","password":"Password1!"}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	CreateUser(w, r)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusUnprocessableEntity, w.Code, w.Body.String())
+	}
+
+	var response struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+		Data    struct {
+			Details []models.FieldError `json:"details"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if !response.Error || response.Message != models.ErrValidation.Error() {
+		t.Errorf("unexpected response %+v", response)
+	}
+
+	fields := make([]string, 0)
+	for _, detail := range response.Data.Details {
+		if detail.Message == "" {
+			t.Errorf("expected a message for field %s", detail.Field)
+		}
+		fields = append(fields, detail.Field)
+	}
+	if strings.Join(fields, ",") != "name,email" {
+		t.Errorf("expected details for name and email, got %v", fields)
+	}
+}
+
+func TestRespondUserError(t *testing.T) {
+	cases := []struct {
+		name     string
+		err      error
+		expected int
+	}{
+		{"validation", models.ValidationErrors{{Field: "name", Message: "User name cannot be empty!"}}, http.StatusUnprocessableEntity},
+		{"email taken", fmt.Errorf("update: %w", models.ValidationErrors{{Field: "email", Message: models.ErrEmailTaken.Error()}}), http.StatusUnprocessableEntity},
+		{"not found", models.ErrUserNotFound, http.StatusNotFound},
+		{"wrapped not found", fmt.Errorf("%w by e-mail", models.ErrUserNotFound), http.StatusNotFound},
+		{"not admin", models.ErrNotAdmin, http.StatusForbidden},
+		{"already active", models.ErrUserAlreadyActive, http.StatusConflict},
+		{"immutable", fmt.Errorf("email: %w", models.ErrImmutableField), http.StatusBadRequest},
+		{"no changes", models.ErrNoChanges, http.StatusBadRequest},
+		{"bad sort", fmt.Errorf("password: %w", models.ErrInvalidSort), http.StatusBadRequest},
+		{"bad order", models.ErrInvalidOrder, http.StatusBadRequest},
+		{"database failure", errors.New("pq: connection refused"), http.StatusInternalServerError},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+
+			respondUserError(w, c.err)
+
+			if w.Code != c.expected {
+				t.Errorf("expected status %d, got %d", c.expected, w.Code)
+			}
+		})
+	}
+}
+
+func TestGetAllUsersErrors(t *testing.T) {
+	t.Run("bad sort", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		GetAllUsers(w, httptest.NewRequest(http.MethodGet, "/api/users?sort=password", nil))
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status %d, got %d", http.StatusBadRequest, w.Code)
+		}
+	})
+
+	t.Run("database failure", func(t *testing.T) {
+		mock := mockDatabase(t)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM "users"`).WillReturnError(errors.New("connection refused"))
+
+		w := httptest.NewRecorder()
+		GetAllUsers(w, httptest.NewRequest(http.MethodGet, "/api/users", nil))
+
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("expected status %d, got %d", http.StatusInternalServerError, w.Code)
+		}
+	})
+}
+
+func TestUpdateUserByIDEmailChange(t *testing.T) {
+	stored := schema.User{ID: 1, Name: "User", Email: "user@example.com"}
+
+	cases := []struct {
+		name      string
+		email     string
+		authEmail string
+		expected  int
+	}{
+		{"email kept", "user@example.com", "other@example.com", http.StatusOK},
+		{"changed by the user", "new@example.com", "user@example.com", http.StatusOK},
+		{"changed by another user", "new@example.com", "other@example.com", http.StatusForbidden},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			updated := stubUserStore(t, stored)
+
+			r := httptest.NewRequest(http.MethodPut, "/api/users", strings.NewReader(`{"id":"1","name":"User","email":"`+c.email+`"}`))
+			r.Header.Set("Content-Type", "application/json")
+			w := authorizedRequest(t, UpdateUserByID, r, c.authEmail)
+
+			if w.Code != c.expected {
+				t.Fatalf("expected status %d, got %d: %s", c.expected, w.Code, w.Body.String())
+			}
+			if (c.expected == http.StatusOK) != (*updated != nil) {
+				t.Errorf("unexpected update %+v", *updated)
+			}
+		})
+	}
+}
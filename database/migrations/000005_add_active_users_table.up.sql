@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN active BOOL NOT NULL DEFAULT TRUE;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN reset_token_expires_at;
+ALTER TABLE users DROP COLUMN reset_token;
\ No newline at end of file
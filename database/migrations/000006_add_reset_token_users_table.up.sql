@@ -0,0 +1,2 @@
+ALTER TABLE users ADD COLUMN reset_token TEXT;
+ALTER TABLE users ADD COLUMN reset_token_expires_at TIMESTAMPTZ;
\ No newline at end of file
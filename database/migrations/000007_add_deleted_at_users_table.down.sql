@@ -0,0 +1,3 @@
+DROP INDEX users_email_not_deleted_key;
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);
+ALTER TABLE users DROP COLUMN deleted_at;
\ No newline at end of file
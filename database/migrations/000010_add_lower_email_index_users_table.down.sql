@@ -0,0 +1,2 @@
+DROP INDEX users_lower_email_not_deleted_key;
+CREATE UNIQUE INDEX users_email_not_deleted_key ON users (email) WHERE deleted_at IS NULL;
\ No newline at end of file
@@ -0,0 +1,4 @@
+-- E-mails are matched by lower(email), so uniqueness ignores case too. Creating the index fails while users
+-- that are not deleted have e-mails differing only in case, merge or delete them before migrating
+DROP INDEX users_email_not_deleted_key;
+CREATE UNIQUE INDEX users_lower_email_not_deleted_key ON users (lower(email)) WHERE deleted_at IS NULL;
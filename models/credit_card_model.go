@@ -4,15 +4,14 @@ import (
 	"boilerplate/database"
 	"boilerplate/models/schema"
 	"context"
+	"database/sql"
 	"errors"
-	"log"
 
 	"github.com/volatiletech/sqlboiler/boil"
-	"github.com/volatiletech/sqlboiler/queries/qm"
 )
 
 // validateCreditCardData is a function to validate credit card before insert into database
-var validateCreditCardData = func(creditCard *schema.CreditCard) (bool, string) {
+var validateCreditCardData = func(ctx context.Context, creditCard *schema.CreditCard) (bool, string) {
 	// Validate if the credit card has a user id
 	if creditCard.UserID == 0 {
 		return false, "Credit card need to have a user!"
@@ -26,7 +25,7 @@ var validateCreditCardData = func(creditCard *schema.CreditCard) (bool, string)
 	}
 
 	// Validate if exist registered credit card with same number
-	existCreditCard, _ := schema.CreditCards(schema.CreditCardWhere.Number.EQ(creditCard.Number)).Exists(context.Background(), database.InstanceDB)
+	existCreditCard, _ := schema.CreditCards(schema.CreditCardWhere.Number.EQ(creditCard.Number)).Exists(ctx, database.InstanceDB)
 	if existCreditCard {
 		return false, "There is already a registered credit card with this number, try another number!"
 	}
@@ -36,29 +35,43 @@ var validateCreditCardData = func(creditCard *schema.CreditCard) (bool, string)
 }
 
 // NewCreditCard is a function to insert a single new credit card into database
-var NewCreditCard = func(creditCard *schema.CreditCard) (*schema.CreditCard, error) {
+var NewCreditCard = func(ctx context.Context, creditCard *schema.CreditCard) (*schema.CreditCard, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	// Validate credit card data to insert
-	if valid, messageError := validateCreditCardData(creditCard); !valid {
+	if valid, messageError := validateCreditCardData(ctx, creditCard); !valid {
 		return nil, errors.New(messageError)
 	}
 
 	// Validate if exist user with creditCard.UserID
-	user, _ := schema.FindUser(context.Background(), database.InstanceDB, creditCard.UserID)
-	if user == nil {
-		return nil, errors.New("not found user")
+	_, err := findUser(ctx, database.InstanceDB, creditCard.UserID, "id")
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+
+		logger.ErrorContext(ctx, "find user", "error", err, "user_id", creditCard.UserID)
+		return nil, err
 	}
 
 	// Insert credit card into database
-	err := creditCard.Insert(context.Background(), database.InstanceDB, boil.Infer())
+	err = creditCard.Insert(ctx, database.InstanceDB, boil.Infer())
 	if err != nil {
-		log.Println(err)
+		logger.ErrorContext(ctx, "insert credit card", "error", err, "user_id", creditCard.UserID)
 		return nil, err
 	}
 
+	// Insert fills the id returned by database, without it the created credit card cannot be found safely
+	if creditCard.ID == 0 {
+		return nil, errors.New("database did not return the id of the created credit card")
+	}
+
 	// Get new credit card created
-	creditCardCreated, err := schema.CreditCards(qm.SQL("select * from users order by id desc")).One(context.Background(), database.InstanceDB)
+	creditCardCreated, err := schema.FindCreditCard(ctx, database.InstanceDB, creditCard.ID)
 	if err != nil {
-		log.Println(err)
+		logger.ErrorContext(ctx, "find created credit card", "error", err, "credit_card_id", creditCard.ID)
 		return nil, err
 	}
 
@@ -66,10 +79,14 @@ var NewCreditCard = func(creditCard *schema.CreditCard) (*schema.CreditCard, err
 }
 
 // GetAllCreditCards is a function to return all credit cards registered in database
-var GetAllCreditCards = func() ([]*schema.CreditCard, error) {
-	allCreditCards, err := schema.CreditCards().All(context.Background(), database.InstanceDB)
+var GetAllCreditCards = func(ctx context.Context) ([]*schema.CreditCard, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	allCreditCards, err := schema.CreditCards().All(ctx, database.InstanceDB)
 	if err != nil {
-		log.Println(err)
+		logger.ErrorContext(ctx, "get all credit cards", "error", err)
 		return nil, err
 	}
 
@@ -77,10 +94,14 @@ var GetAllCreditCards = func() ([]*schema.CreditCard, error) {
 }
 
 // GetCreditCardByID is a function to return a single credit card
-var GetCreditCardByID = func(creditCardId int) (*schema.CreditCard, error) {
-	creditCard, err := schema.FindCreditCard(context.Background(), database.InstanceDB, creditCardId)
+var GetCreditCardByID = func(ctx context.Context, creditCardId int) (*schema.CreditCard, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	creditCard, err := schema.FindCreditCard(ctx, database.InstanceDB, creditCardId)
 	if err != nil {
-		log.Println(err)
+		logger.ErrorContext(ctx, "find credit card", "error", err, "credit_card_id", creditCardId)
 		return nil, err
 	}
 
@@ -88,17 +109,21 @@ var GetCreditCardByID = func(creditCardId int) (*schema.CreditCard, error) {
 }
 
 // UpdateCreditCard is a function to update data from a single credit card
-var UpdateCreditCard = func(creditCardToUpdate *schema.CreditCard) (int64, error) {
+var UpdateCreditCard = func(ctx context.Context, creditCardToUpdate *schema.CreditCard) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
 	// Validate if exist credit card with creditCardToUpdate.UserID
-	creditCard, _ := schema.FindCreditCard(context.Background(), database.InstanceDB, creditCardToUpdate.ID)
+	creditCard, _ := schema.FindCreditCard(ctx, database.InstanceDB, creditCardToUpdate.ID)
 	if creditCard == nil {
 		return 0, errors.New("not found user")
 	}
 
 	// Update credit card with creditCardToUpdate data
-	rowsAff, err := creditCardToUpdate.Update(context.Background(), database.InstanceDB, boil.Whitelist("number", "active")) // only update number and active columns
+	rowsAff, err := creditCardToUpdate.Update(ctx, database.InstanceDB, boil.Whitelist("number", "active")) // only update number and active columns
 	if err != nil {
-		log.Println(err)
+		logger.ErrorContext(ctx, "update credit card", "error", err, "credit_card_id", creditCardToUpdate.ID)
 		return 0, err
 	}
 
@@ -112,17 +137,21 @@ var UpdateCreditCard = func(creditCardToUpdate *schema.CreditCard) (int64, error
 }
 
 // DeleteCreditCardByID is a function to delete a single credit card
-var DeleteCreditCardByID = func(creditCardID int) (int64, error) {
+var DeleteCreditCardByID = func(ctx context.Context, creditCardID int) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
 	// Validate if exist credit card with id equal to creditCardID
-	creditCard, _ := schema.FindCreditCard(context.Background(), database.InstanceDB, creditCardID)
+	creditCard, _ := schema.FindCreditCard(ctx, database.InstanceDB, creditCardID)
 	if creditCard == nil {
 		return 0, errors.New("not found user")
 	}
 
 	// Delete credit card with id equal to creditCardID
-	rowsAff, err := creditCard.Delete(context.Background(), database.InstanceDB)
+	rowsAff, err := creditCard.Delete(ctx, database.InstanceDB)
 	if err != nil {
-		log.Println(err)
+		logger.ErrorContext(ctx, "delete credit card", "error", err, "credit_card_id", creditCard.ID)
 		return 0, err
 	}
 
@@ -136,17 +165,26 @@ var DeleteCreditCardByID = func(creditCardID int) (int64, error) {
 }
 
 // GetAllCreditCardsByUser is a function to return all credit cards by a single user
-var GetAllCreditCardsByUser = func(userId int) ([]*schema.CreditCard, error) {
+var GetAllCreditCardsByUser = func(ctx context.Context, userId int) ([]*schema.CreditCard, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	// Validate if exist user with id equal to userId
-	user, _ := schema.FindUser(context.Background(), database.InstanceDB, userId)
-	if user == nil {
-		return nil, errors.New("not found user")
+	user, err := findUser(ctx, database.InstanceDB, userId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+
+		logger.ErrorContext(ctx, "find user", "error", err, "user_id", userId)
+		return nil, err
 	}
 
 	// Get all credit cards by user
-	creditCards, err := user.CreditCards().All(context.Background(), database.InstanceDB)
+	creditCards, err := user.CreditCards().All(ctx, database.InstanceDB)
 	if err != nil {
-		log.Println(err)
+		logger.ErrorContext(ctx, "get credit cards of user", "error", err, "user_id", userId)
 		return nil, err
 	}
 
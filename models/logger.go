@@ -0,0 +1,22 @@
+package models
+
+import (
+	"io"
+	"log/slog"
+)
+
+// discardLogger is the logger used until SetLogger is called
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// logger is the logger of the models functions
+var logger = discardLogger
+
+// SetLogger is a function to set the logger of the models functions, a nil logger discards all the logs.
+// Errors are logged with the request context, so handlers of the logger can read values like trace ids from it
+var SetLogger = func(l *slog.Logger) {
+	if l == nil {
+		l = discardLogger
+	}
+
+	logger = l
+}
@@ -24,28 +24,52 @@ import (
 
 // User is an object representing the database table.
 type User struct {
-	ID           int         `boil:"id" json:"id" toml:"id" yaml:"id"`
-	Name         string      `boil:"name" json:"name" toml:"name" yaml:"name"`
-	Email        string      `boil:"email" json:"email" toml:"email" yaml:"email"`
-	Password     string      `boil:"password" json:"password" toml:"password" yaml:"password"`
-	RefreshToken null.String `boil:"refresh_token" json:"refresh_token,omitempty" toml:"refresh_token" yaml:"refresh_token,omitempty"`
+	ID                  int         `boil:"id" json:"id" toml:"id" yaml:"id"`
+	Name                string      `boil:"name" json:"name" toml:"name" yaml:"name"`
+	Email               string      `boil:"email" json:"email" toml:"email" yaml:"email"`
+	Password            string      `boil:"password" json:"password" toml:"password" yaml:"password"`
+	RefreshToken        null.String `boil:"refresh_token" json:"refresh_token,omitempty" toml:"refresh_token" yaml:"refresh_token,omitempty"`
+	CreatedAt           time.Time   `boil:"created_at" json:"created_at" toml:"created_at" yaml:"created_at"`
+	Active              bool        `boil:"active" json:"active" toml:"active" yaml:"active"`
+	ResetToken          null.String `boil:"reset_token" json:"reset_token,omitempty" toml:"reset_token" yaml:"reset_token,omitempty"`
+	ResetTokenExpiresAt null.Time   `boil:"reset_token_expires_at" json:"reset_token_expires_at,omitempty" toml:"reset_token_expires_at" yaml:"reset_token_expires_at,omitempty"`
+	DeletedAt           null.Time   `boil:"deleted_at" json:"deleted_at,omitempty" toml:"deleted_at" yaml:"deleted_at,omitempty"`
+	FailedLoginAttempts int         `boil:"failed_login_attempts" json:"failed_login_attempts" toml:"failed_login_attempts" yaml:"failed_login_attempts"`
+	LockedUntil         null.Time   `boil:"locked_until" json:"locked_until,omitempty" toml:"locked_until" yaml:"locked_until,omitempty"`
+	Role                string      `boil:"role" json:"role" toml:"role" yaml:"role"`
 
 	R *userR `boil:"-" json:"-" toml:"-" yaml:"-"`
 	L userL  `boil:"-" json:"-" toml:"-" yaml:"-"`
 }
 
 var UserColumns = struct {
-	ID           string
-	Name         string
-	Email        string
-	Password     string
-	RefreshToken string
+	ID                  string
+	Name                string
+	Email               string
+	Password            string
+	RefreshToken        string
+	CreatedAt           string
+	Active              string
+	ResetToken          string
+	ResetTokenExpiresAt string
+	DeletedAt           string
+	FailedLoginAttempts string
+	LockedUntil         string
+	Role                string
 }{
-	ID:           "id",
-	Name:         "name",
-	Email:        "email",
-	Password:     "password",
-	RefreshToken: "refresh_token",
+	ID:                  "id",
+	Name:                "name",
+	Email:               "email",
+	Password:            "password",
+	RefreshToken:        "refresh_token",
+	CreatedAt:           "created_at",
+	Active:              "active",
+	ResetToken:          "reset_token",
+	ResetTokenExpiresAt: "reset_token_expires_at",
+	DeletedAt:           "deleted_at",
+	FailedLoginAttempts: "failed_login_attempts",
+	LockedUntil:         "locked_until",
+	Role:                "role",
 }
 
 // Generated where
@@ -73,18 +97,78 @@ func (w whereHelpernull_String) GTE(x null.String) qm.QueryMod {
 	return qmhelper.Where(w.field, qmhelper.GTE, x)
 }
 
+type whereHelpertime_Time struct{ field string }
+
+func (w whereHelpertime_Time) EQ(x time.Time) qm.QueryMod {
+	return qmhelper.Where(w.field, qmhelper.EQ, x)
+}
+func (w whereHelpertime_Time) NEQ(x time.Time) qm.QueryMod {
+	return qmhelper.Where(w.field, qmhelper.NEQ, x)
+}
+func (w whereHelpertime_Time) LT(x time.Time) qm.QueryMod {
+	return qmhelper.Where(w.field, qmhelper.LT, x)
+}
+func (w whereHelpertime_Time) LTE(x time.Time) qm.QueryMod {
+	return qmhelper.Where(w.field, qmhelper.LTE, x)
+}
+func (w whereHelpertime_Time) GT(x time.Time) qm.QueryMod {
+	return qmhelper.Where(w.field, qmhelper.GT, x)
+}
+func (w whereHelpertime_Time) GTE(x time.Time) qm.QueryMod {
+	return qmhelper.Where(w.field, qmhelper.GTE, x)
+}
+
+type whereHelpernull_Time struct{ field string }
+
+func (w whereHelpernull_Time) EQ(x null.Time) qm.QueryMod {
+	return qmhelper.WhereNullEQ(w.field, false, x)
+}
+func (w whereHelpernull_Time) NEQ(x null.Time) qm.QueryMod {
+	return qmhelper.WhereNullEQ(w.field, true, x)
+}
+func (w whereHelpernull_Time) IsNull() qm.QueryMod    { return qmhelper.WhereIsNull(w.field) }
+func (w whereHelpernull_Time) IsNotNull() qm.QueryMod { return qmhelper.WhereIsNotNull(w.field) }
+func (w whereHelpernull_Time) LT(x null.Time) qm.QueryMod {
+	return qmhelper.Where(w.field, qmhelper.LT, x)
+}
+func (w whereHelpernull_Time) LTE(x null.Time) qm.QueryMod {
+	return qmhelper.Where(w.field, qmhelper.LTE, x)
+}
+func (w whereHelpernull_Time) GT(x null.Time) qm.QueryMod {
+	return qmhelper.Where(w.field, qmhelper.GT, x)
+}
+func (w whereHelpernull_Time) GTE(x null.Time) qm.QueryMod {
+	return qmhelper.Where(w.field, qmhelper.GTE, x)
+}
+
 var UserWhere = struct {
-	ID           whereHelperint
-	Name         whereHelperstring
-	Email        whereHelperstring
-	Password     whereHelperstring
-	RefreshToken whereHelpernull_String
+	ID                  whereHelperint
+	Name                whereHelperstring
+	Email               whereHelperstring
+	Password            whereHelperstring
+	RefreshToken        whereHelpernull_String
+	CreatedAt           whereHelpertime_Time
+	Active              whereHelperbool
+	ResetToken          whereHelpernull_String
+	ResetTokenExpiresAt whereHelpernull_Time
+	DeletedAt           whereHelpernull_Time
+	FailedLoginAttempts whereHelperint
+	LockedUntil         whereHelpernull_Time
+	Role                whereHelperstring
 }{
-	ID:           whereHelperint{field: "\"users\".\"id\""},
-	Name:         whereHelperstring{field: "\"users\".\"name\""},
-	Email:        whereHelperstring{field: "\"users\".\"email\""},
-	Password:     whereHelperstring{field: "\"users\".\"password\""},
-	RefreshToken: whereHelpernull_String{field: "\"users\".\"refresh_token\""},
+	ID:                  whereHelperint{field: "\"users\".\"id\""},
+	Name:                whereHelperstring{field: "\"users\".\"name\""},
+	Email:               whereHelperstring{field: "\"users\".\"email\""},
+	Password:            whereHelperstring{field: "\"users\".\"password\""},
+	RefreshToken:        whereHelpernull_String{field: "\"users\".\"refresh_token\""},
+	CreatedAt:           whereHelpertime_Time{field: "\"users\".\"created_at\""},
+	Active:              whereHelperbool{field: "\"users\".\"active\""},
+	ResetToken:          whereHelpernull_String{field: "\"users\".\"reset_token\""},
+	ResetTokenExpiresAt: whereHelpernull_Time{field: "\"users\".\"reset_token_expires_at\""},
+	DeletedAt:           whereHelpernull_Time{field: "\"users\".\"deleted_at\""},
+	FailedLoginAttempts: whereHelperint{field: "\"users\".\"failed_login_attempts\""},
+	LockedUntil:         whereHelpernull_Time{field: "\"users\".\"locked_until\""},
+	Role:                whereHelperstring{field: "\"users\".\"role\""},
 }
 
 // UserRels is where relationship names are stored.
@@ -108,9 +192,9 @@ func (*userR) NewStruct() *userR {
 type userL struct{}
 
 var (
-	userAllColumns            = []string{"id", "name", "email", "password", "refresh_token"}
-	userColumnsWithoutDefault = []string{"name", "email", "password", "refresh_token"}
-	userColumnsWithDefault    = []string{"id"}
+	userAllColumns            = []string{"id", "name", "email", "password", "refresh_token", "created_at", "active", "reset_token", "reset_token_expires_at", "deleted_at", "failed_login_attempts", "locked_until", "role"}
+	userColumnsWithoutDefault = []string{"name", "email", "password", "refresh_token", "reset_token", "reset_token_expires_at", "deleted_at", "locked_until"}
+	userColumnsWithDefault    = []string{"id", "created_at", "active", "failed_login_attempts", "role"}
 	userPrimaryKeyColumns     = []string{"id"}
 )
 
@@ -598,6 +682,13 @@ func (o *User) Insert(ctx context.Context, exec boil.ContextExecutor, columns bo
 	}
 
 	var err error
+	if !boil.TimestampsAreSkipped(ctx) {
+		currTime := time.Now().In(boil.GetLocation())
+
+		if o.CreatedAt.IsZero() {
+			o.CreatedAt = currTime
+		}
+	}
 
 	if err := o.doBeforeInsertHooks(ctx, exec); err != nil {
 		return err
@@ -803,6 +894,13 @@ func (o *User) Upsert(ctx context.Context, exec boil.ContextExecutor, updateOnCo
 	if o == nil {
 		return errors.New("schema: no users provided for upsert")
 	}
+	if !boil.TimestampsAreSkipped(ctx) {
+		currTime := time.Now().In(boil.GetLocation())
+
+		if o.CreatedAt.IsZero() {
+			o.CreatedAt = currTime
+		}
+	}
 
 	if err := o.doBeforeUpsertHooks(ctx, exec); err != nil {
 		return err
@@ -722,7 +722,7 @@ func testUsersSelect(t *testing.T) {
 }
 
 var (
-	userDBTypes = map[string]string{`ID`: `integer`, `Name`: `text`, `Email`: `text`, `Password`: `text`, `RefreshToken`: `text`}
+	userDBTypes = map[string]string{`ID`: `integer`, `Name`: `text`, `Email`: `text`, `Password`: `text`, `RefreshToken`: `text`, `CreatedAt`: `timestamp with time zone`, `Active`: `boolean`, `ResetToken`: `text`, `ResetTokenExpiresAt`: `timestamp with time zone`, `DeletedAt`: `timestamp with time zone`, `FailedLoginAttempts`: `integer`, `LockedUntil`: `timestamp with time zone`, `Role`: `text`}
 	_           = bytes.MinRead
 )
 
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"boilerplate/models/schema"
+	"context"
+)
+
+// UserHookFunc is a function type to run custom logic on user events without editing the model functions
+type UserHookFunc func(ctx context.Context, user *schema.User) error
+
+// Hooks executed by the user model functions, all of them are empty by default.
+// A before hook returning an error aborts the operation, after hook errors are only logged because the change was
+// already written into database
+var (
+	BeforeCreate []UserHookFunc
+	AfterCreate  []UserHookFunc
+	AfterUpdate  []UserHookFunc
+	AfterDelete  []UserHookFunc
+)
+
+// runBeforeUserHooks is a function to execute before hooks, stopping in the first error
+var runBeforeUserHooks = func(ctx context.Context, hooks []UserHookFunc, user *schema.User) error {
+	for _, hook := range hooks {
+		if err := hook(ctx, user); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runAfterUserHooks is a function to execute after hooks, logging errors without stopping
+var runAfterUserHooks = func(ctx context.Context, hooks []UserHookFunc, user *schema.User) {
+	for _, hook := range hooks {
+		if err := hook(ctx, user); err != nil {
+			logger.WarnContext(ctx, "after user hook failed", "error", err, "user_id", user.ID)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package models
+
+import (
+	"boilerplate/models/schema"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// recordHook is a function to create a hook that appends name to calls and returns err
+func recordHook(calls *[]string, name string, err error) UserHookFunc {
+	return func(ctx context.Context, user *schema.User) error {
+		*calls = append(*calls, name)
+		return err
+	}
+}
+
+func TestRunBeforeUserHooks(t *testing.T) {
+	hookErr := errors.New("rejected")
+	calls := make([]string, 0)
+	hooks := []UserHookFunc{recordHook(&calls, "first", nil), recordHook(&calls, "second", hookErr), recordHook(&calls, "third", nil)}
+
+	err := runBeforeUserHooks(context.Background(), hooks, &schema.User{})
+
+	if !errors.Is(err, hookErr) {
+		t.Fatalf("expected hook error, got %v", err)
+	}
+	if strings.Join(calls, ",") != "first,second" {
+		t.Errorf("expected hooks to stop in the first error, got %v", calls)
+	}
+}
+
+func TestRunAfterUserHooks(t *testing.T) {
+	logs := captureLogs(t)
+	calls := make([]string, 0)
+	hooks := []UserHookFunc{recordHook(&calls, "first", errors.New("failed")), recordHook(&calls, "second", nil)}
+
+	runAfterUserHooks(context.Background(), hooks, &schema.User{ID: 7})
+
+	if strings.Join(calls, ",") != "first,second" {
+		t.Errorf("expected every hook to run, got %v", calls)
+	}
+	if !strings.Contains(logs.String(), `"msg":"after user hook failed"`) || !strings.Contains(logs.String(), `"user_id":7`) {
+		t.Errorf("expected hook error to be logged, got %s", logs.String())
+	}
+}
+
+func TestBeforeCreateErrorAbortsNewUser(t *testing.T) {
+	t.Setenv("bcrypt_cost", "4")
+	hookErr := errors.New("signups are closed")
+	calls := make([]string, 0)
+
+	previousBefore, previousAfter := BeforeCreate, AfterCreate
+	BeforeCreate = []UserHookFunc{recordHook(&calls, "before", hookErr)}
+	AfterCreate = []UserHookFunc{recordHook(&calls, "after", nil)}
+	t.Cleanup(func() { BeforeCreate, AfterCreate = previousBefore, previousAfter })
+
+	// No insert is expected after the hook error
+	mock := mockDatabase(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM "users"`).
+		WithArgs("new@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectRollback()
+
+	_, err := NewUser(context.Background(), &schema.User{Name: "User", Email: "new@example.com", Password: "secret123"})
+
+	if !errors.Is(err, hookErr) {
+		t.Fatalf("expected hook error, got %v", err)
+	}
+	if strings.Join(calls, ",") != "before" {
+		t.Errorf("expected only the before hook to run, got %v", calls)
+	}
+}
+
+func TestAfterUpdateRunsOnApproveAndRestore(t *testing.T) {
+	ctx := context.Background()
+	calls := make([]string, 0)
+
+	previousAfter := AfterUpdate
+	AfterUpdate = []UserHookFunc{func(ctx context.Context, user *schema.User) error {
+		calls = append(calls, user.Email)
+		return nil
+	}}
+	t.Cleanup(func() { AfterUpdate = previousAfter })
+
+	mock := mockDatabase(t)
+
+	t.Run("approve", func(t *testing.T) {
+		calls = calls[:0]
+		expectAdmin(mock, 1, RoleAdmin)
+		mock.ExpectQuery(`SELECT "id", "name", "email", "active" FROM "users"`).
+			WithArgs(2).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email", "active"}).AddRow(2, "Pending", "pending@example.com", false))
+		mock.ExpectExec(`UPDATE "users" SET "active"=\$1 WHERE "id"=\$2`).
+			WithArgs(true, 2).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		if _, err := ApproveUser(ctx, 1, 2); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(calls, ",") != "pending@example.com" {
+			t.Errorf("expected after update hook with the approved user, got %v", calls)
+		}
+	})
+
+	t.Run("restore", func(t *testing.T) {
+		calls = calls[:0]
+		mock.ExpectQuery(`SELECT "id", "name", "email" FROM "users"`).
+			WithArgs(3).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email"}).AddRow(3, "Deleted", "deleted@example.com"))
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM "users"`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectExec(`UPDATE "users" SET "deleted_at"=\$1 WHERE "id"=\$2`).
+			WithArgs(nil, 3).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		if _, err := RestoreUserByID(ctx, 3); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(calls, ",") != "deleted@example.com" {
+			t.Errorf("expected after update hook with the restored user, got %v", calls)
+		}
+	})
+
+	t.Run("failed update skips hooks", func(t *testing.T) {
+		calls = calls[:0]
+		expectAdmin(mock, 1, RoleAdmin)
+		mock.ExpectQuery(`SELECT "id", "name", "email", "active" FROM "users"`).
+			WithArgs(2).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email", "active"}).AddRow(2, "Pending", "pending@example.com", false))
+		mock.ExpectExec(`UPDATE "users" SET "active"=\$1 WHERE "id"=\$2`).
+			WillReturnError(errors.New("connection lost"))
+
+		if _, err := ApproveUser(ctx, 1, 2); err == nil {
+			t.Fatal("expected update error")
+		}
+		if len(calls) != 0 {
+			t.Errorf("expected no after update hook, got %v", calls)
+		}
+	})
+}
@@ -0,0 +1,423 @@
+package models
+
+import (
+	"boilerplate/database"
+	"boilerplate/models/schema"
+	u "boilerplate/utils"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/volatiletech/sqlboiler/boil"
+	"github.com/volatiletech/sqlboiler/queries/qm"
+)
+
+// ImportRowResult is a struct that stores the validation result of a single imported row
+type ImportRowResult struct {
+	Row    int              `json:"row"`
+	Email  string           `json:"email"`
+	Valid  bool             `json:"valid"`
+	Errors ValidationErrors `json:"errors,omitempty"`
+}
+
+// ImportReport is a struct that stores the validation result of all imported rows
+type ImportReport struct {
+	Rows    []ImportRowResult `json:"rows"`
+	Valid   int               `json:"valid"`
+	Invalid int               `json:"invalid"`
+}
+
+// parseImportCSV is a function to read users from a CSV with a header row containing name, email and password columns
+var parseImportCSV = func(r io.Reader) ([]*schema.User, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, errors.New("csv file is empty")
+		}
+		return nil, err
+	}
+
+	// Find the position of each column in header
+	columns := map[string]int{"name": -1, "email": -1, "password": -1}
+	for i, column := range header {
+		column = strings.ToLower(strings.TrimSpace(column))
+		if _, ok := columns[column]; ok {
+			columns[column] = i
+		}
+	}
+
+	for column, i := range columns {
+		if i < 0 {
+			return nil, errors.New("csv header is missing column " + column)
+		}
+	}
+
+	users := make([]*schema.User, 0)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, &schema.User{
+			Name:     strings.TrimSpace(record[columns["name"]]),
+			Email:    normalizeEmail(record[columns["email"]]),
+			Password: record[columns["password"]],
+		})
+	}
+
+	return users, nil
+}
+
+// ValidateImportCSV is a function to validate every row of a users CSV without inserting anything.
+// Besides the user validation, emails repeated inside the file itself are reported
+var ValidateImportCSV = func(ctx context.Context, r io.Reader) (ImportReport, error) {
+	if err := ctx.Err(); err != nil {
+		return ImportReport{}, err
+	}
+
+	report := ImportReport{Rows: make([]ImportRowResult, 0)}
+
+	users, err := parseImportCSV(r)
+	if err != nil {
+		return report, err
+	}
+
+	// Row of the first occurrence of each email in file
+	firstRows := make(map[string]int)
+
+	for i, user := range users {
+		row := i + 2 // header is row 1
+		result := ImportRowResult{Row: row, Email: user.Email}
+
+		if err := validateUserData(ctx, database.InstanceDB, user); err != nil {
+			var validationErrors ValidationErrors
+			if !errors.As(err, &validationErrors) {
+				return report, err
+			}
+			result.Errors = append(result.Errors, validationErrors...)
+		}
+
+		if user.Email != "" {
+			if firstRow, ok := firstRows[user.Email]; ok {
+				result.Errors = append(result.Errors, FieldError{Field: "email", Message: "E-mail is repeated in row " + strconv.Itoa(firstRow) + "!"})
+			} else {
+				firstRows[user.Email] = row
+			}
+		}
+
+		result.Valid = len(result.Errors) == 0
+		if result.Valid {
+			report.Valid++
+		} else {
+			report.Invalid++
+		}
+
+		report.Rows = append(report.Rows, result)
+	}
+
+	return report, nil
+}
+
+// BatchMode is how NewUsersBatch handles invalid users
+type BatchMode int
+
+const (
+	// BatchAllOrNothing inserts no user when any user of the batch is invalid
+	BatchAllOrNothing BatchMode = iota
+	// BatchPartial inserts the valid users and only reports the invalid ones
+	BatchPartial
+)
+
+// BatchUserResult is a struct that stores the outcome of a single user of NewUsersBatch
+type BatchUserResult struct {
+	Index  int              `json:"index"`
+	ID     int              `json:"id,omitempty"` // id of the inserted user, zero when it was not inserted
+	Errors ValidationErrors `json:"errors,omitempty"`
+}
+
+// maxBatchStatementUsers is the maximum number of users of a single statement of NewUsersBatch, keeping the
+// statement parameters below the postgres limit
+const maxBatchStatementUsers = 1000
+
+// batchUserChunks is a function to split users into chunks of maxBatchStatementUsers users
+var batchUserChunks = func(users []*schema.User) [][]*schema.User {
+	chunks := make([][]*schema.User, 0, len(users)/maxBatchStatementUsers+1)
+	for len(users) > maxBatchStatementUsers {
+		chunks = append(chunks, users[:maxBatchStatementUsers])
+		users = users[maxBatchStatementUsers:]
+	}
+	if len(users) > 0 {
+		chunks = append(chunks, users)
+	}
+
+	return chunks
+}
+
+// findTakenEmails is a function to return which emails of users are already registered by a user that is not soft
+// deleted. Emails are compared with lower(email), like the unique index, and returned lowercased
+var findTakenEmails = func(ctx context.Context, exec boil.ContextExecutor, users []*schema.User) (map[string]bool, error) {
+	taken := make(map[string]bool)
+
+	for _, chunk := range batchUserChunks(users) {
+		emails := make([]interface{}, 0, len(chunk))
+		for _, user := range chunk {
+			emails = append(emails, strings.ToLower(user.Email))
+		}
+
+		existing, err := schema.Users(
+			qm.Select("email"),
+			qm.WhereIn("lower(email) IN ?", emails...),
+			schema.UserWhere.DeletedAt.IsNull(),
+		).All(ctx, exec)
+		if err != nil {
+			logger.ErrorContext(ctx, "check e-mails of users batch", "error", err)
+			return nil, err
+		}
+
+		for _, user := range existing {
+			taken[strings.ToLower(user.Email)] = true
+		}
+	}
+
+	return taken, nil
+}
+
+// insertUsers is a function to insert users with one multi-row statement per chunk, filling the id of each user.
+// Only name, email, password and active are inserted, the other columns get their database defaults
+var insertUsers = func(ctx context.Context, exec boil.ContextExecutor, users []*schema.User) error {
+	for _, chunk := range batchUserChunks(users) {
+		values := make([]string, 0, len(chunk))
+		args := make([]interface{}, 0, len(chunk)*4)
+		for i, user := range chunk {
+			values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d)", i*4+1, i*4+2, i*4+3, i*4+4))
+			args = append(args, user.Name, user.Email, user.Password, user.Active)
+		}
+
+		rows, err := exec.QueryContext(ctx, "INSERT INTO users (name, email, password, active) VALUES "+strings.Join(values, ", ")+" RETURNING id, lower(email)", args...)
+		if err != nil {
+			return err
+		}
+
+		// Emails are unique ignoring case inside the batch, so they identify the returned ids
+		ids := make(map[string]int, len(chunk))
+		for rows.Next() {
+			var id int
+			var email string
+			if err := rows.Scan(&id, &email); err != nil {
+				rows.Close()
+				return err
+			}
+			ids[email] = id
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, user := range chunk {
+			user.ID = ids[strings.ToLower(user.Email)]
+			if user.ID == 0 {
+				return errors.New("database did not return the id of the created user " + user.Email)
+			}
+		}
+	}
+
+	return nil
+}
+
+// NewUsersBatch is a function to validate and insert users in a single transaction, returning the outcome of each
+// user in the same order. Besides the user validation, emails repeated inside the batch are reported.
+// Emails are checked against database with a single query and valid users are inserted with a single statement.
+// With BatchAllOrNothing any invalid user rolls back the whole batch and ErrValidation is returned with the results,
+// with BatchPartial the valid users are committed. Other errors (e.g. a before create hook) abort the whole batch.
+// Users are copied before normalizing and hashing, so users is not modified and can be retried after an error
+var NewUsersBatch = func(ctx context.Context, users []*schema.User, mode BatchMode) ([]BatchUserResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	tx, err := database.InstanceDB.BeginTx(ctx, nil)
+	if err != nil {
+		logger.ErrorContext(ctx, "begin users batch transaction", "error", err)
+		return nil, err
+	}
+	defer tx.Rollback() // no effect after commit
+
+	results := make([]BatchUserResult, len(users))
+	batchUsers := make([]*schema.User, len(users))
+	checkUsers := make([]*schema.User, 0, len(users))
+
+	// Index of the first occurrence of each email in batch, ignoring case like the unique index
+	firstIndexes := make(map[string]int)
+
+	for i, user := range users {
+		batchUser := *user
+		batchUser.Email = normalizeEmail(batchUser.Email)
+		batchUsers[i] = &batchUser
+		results[i].Index = i
+		results[i].Errors = validateUserFields(&batchUser)
+
+		if validateEmail(batchUser.Email) != nil {
+			continue
+		}
+
+		email := strings.ToLower(batchUser.Email)
+		if firstIndex, ok := firstIndexes[email]; ok {
+			results[i].Errors = append(results[i].Errors, FieldError{Field: "email", Message: "E-mail is repeated at index " + strconv.Itoa(firstIndex) + "!", err: ErrEmailTaken})
+			continue
+		}
+		firstIndexes[email] = i
+		checkUsers = append(checkUsers, &batchUser)
+	}
+
+	// markTakenEmails is a function to report the users whose email is in taken, returning how many were found
+	markTakenEmails := func(taken map[string]bool) int {
+		found := 0
+		for i, batchUser := range batchUsers {
+			if len(results[i].Errors) == 0 && taken[strings.ToLower(batchUser.Email)] {
+				results[i].Errors = ValidationErrors{emailTakenError}
+				found++
+			}
+		}
+		return found
+	}
+
+	taken, err := findTakenEmails(ctx, tx, checkUsers)
+	if err != nil {
+		return nil, err
+	}
+	markTakenEmails(taken)
+
+	invalid := 0
+	for i := range results {
+		if len(results[i].Errors) > 0 {
+			invalid++
+		}
+	}
+
+	if invalid > 0 && mode == BatchAllOrNothing {
+		return results, ErrValidation
+	}
+
+	// New users start inactive when admin approval is required
+	active := !u.GetEnvBool("require_approval", false)
+
+	validUsers := make([]*schema.User, 0, len(users)-invalid)
+	validIndexes := make([]int, 0, len(users)-invalid)
+	for i, batchUser := range batchUsers {
+		if len(results[i].Errors) > 0 {
+			continue
+		}
+
+		// Hash password after validation, because the password policy applies to the plaintext
+		hash, err := hashPassword(batchUser.Password)
+		if err != nil {
+			logger.ErrorContext(ctx, "hash password", "error", err, "email", batchUser.Email)
+			return nil, err
+		}
+		batchUser.Password = hash
+
+		// Run before create hooks, any error aborts the batch
+		if err := runBeforeUserHooks(ctx, BeforeCreate, batchUser); err != nil {
+			return nil, err
+		}
+
+		batchUser.Active = active
+		validUsers = append(validUsers, batchUser)
+		validIndexes = append(validIndexes, i)
+	}
+
+	// A failed statement aborts a postgres transaction, the savepoint keeps it usable for the fallback of BatchPartial
+	if mode == BatchPartial && len(validUsers) > 0 {
+		if _, err := tx.ExecContext(ctx, "SAVEPOINT batch_users"); err != nil {
+			logger.ErrorContext(ctx, "create users batch savepoint", "error", err)
+			return nil, err
+		}
+	}
+
+	err = insertUsers(ctx, tx, validUsers)
+	if err != nil && !isUniqueViolation(err) {
+		logger.ErrorContext(ctx, "insert users batch", "error", err)
+		return nil, err
+	}
+
+	// A concurrent signup inserted one of the emails after the check
+	if err != nil && mode == BatchAllOrNothing {
+		// The transaction is aborted, so the taken emails are found outside of it
+		tx.Rollback()
+		taken, err := findTakenEmails(ctx, database.InstanceDB, validUsers)
+		if err != nil {
+			return nil, err
+		}
+		if markTakenEmails(taken) == 0 {
+			return nil, ValidationErrors{emailTakenError}
+		}
+		return results, ErrValidation
+	}
+
+	// BatchPartial falls back to insert users one at a time, reporting the ones whose email was taken
+	if err != nil {
+		if _, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT batch_users"); err != nil {
+			logger.ErrorContext(ctx, "rollback to users batch savepoint", "error", err)
+			return nil, err
+		}
+
+		for j, batchUser := range validUsers {
+			batchUser.ID = 0
+
+			if _, err := tx.ExecContext(ctx, "SAVEPOINT batch_user"); err != nil {
+				logger.ErrorContext(ctx, "create users batch savepoint", "error", err)
+				return nil, err
+			}
+
+			if err := insertUsers(ctx, tx, []*schema.User{batchUser}); err != nil {
+				if !isUniqueViolation(err) {
+					logger.ErrorContext(ctx, "insert user", "error", err, "email", batchUser.Email)
+					return nil, err
+				}
+
+				if _, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT batch_user"); err != nil {
+					logger.ErrorContext(ctx, "rollback to users batch savepoint", "error", err)
+					return nil, err
+				}
+
+				batchUser.ID = 0
+				results[validIndexes[j]].Errors = ValidationErrors{emailTakenError}
+				continue
+			}
+
+			if _, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT batch_user"); err != nil {
+				logger.ErrorContext(ctx, "release users batch savepoint", "error", err)
+				return nil, err
+			}
+		}
+	}
+
+	for i, batchUser := range batchUsers {
+		results[i].ID = batchUser.ID
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.ErrorContext(ctx, "commit users batch", "error", err)
+		return nil, err
+	}
+
+	for i, batchUser := range batchUsers {
+		if results[i].ID != 0 {
+			runAfterUserHooks(ctx, AfterCreate, &schema.User{ID: batchUser.ID, Name: batchUser.Name, Email: batchUser.Email}) // only id, name and email columns, like NewUser
+		}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,247 @@
+package models
+
+import (
+	"boilerplate/models/schema"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+// newBatchUsers is a function to create users with a valid name and password for each email
+func newBatchUsers(emails ...string) []*schema.User {
+	users := make([]*schema.User, 0, len(emails))
+	for _, email := range emails {
+		users = append(users, &schema.User{Name: "User", Email: email, Password: "secret123"})
+	}
+
+	return users
+}
+
+// expectTakenEmails is a function to expect the query of findTakenEmails with emails, returning taken as the
+// registered emails
+func expectTakenEmails(mock sqlmock.Sqlmock, emails []string, taken ...string) {
+	args := make([]driver.Value, 0, len(emails))
+	for _, email := range emails {
+		args = append(args, email)
+	}
+
+	rows := sqlmock.NewRows([]string{"email"})
+	for _, email := range taken {
+		rows.AddRow(email)
+	}
+
+	mock.ExpectQuery(`SELECT "email" FROM "users" WHERE \(lower\(email\) IN \(`).WithArgs(args...).WillReturnRows(rows)
+}
+
+// expectInsertUsers is a function to expect a single insert statement of users with emails
+func expectInsertUsers(mock sqlmock.Sqlmock, emails ...string) *sqlmock.ExpectedQuery {
+	args := make([]driver.Value, 0, len(emails)*4)
+	for _, email := range emails {
+		args = append(args, "User", email, sqlmock.AnyArg(), true)
+	}
+
+	return mock.ExpectQuery(`INSERT INTO users \(name, email, password, active\) VALUES`).WithArgs(args...)
+}
+
+// insertedRows is a function to create the rows returned by the insert of users, in the order of emails
+func insertedRows(ids map[string]int, emails ...string) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"id", "lower"})
+	for _, email := range emails {
+		rows.AddRow(ids[email], email)
+	}
+
+	return rows
+}
+
+// checkResultErrors is a function to check that results have errors only at the indexes of invalid
+func checkResultErrors(t *testing.T, results []BatchUserResult, invalid ...int) {
+	t.Helper()
+
+	isInvalid := make(map[int]bool)
+	for _, index := range invalid {
+		isInvalid[index] = true
+	}
+
+	for i, result := range results {
+		if isInvalid[i] != (len(result.Errors) > 0) {
+			t.Errorf("unexpected errors of index %d: %+v", i, result.Errors)
+		}
+		if isInvalid[i] && result.ID != 0 {
+			t.Errorf("expected index %d not to be inserted, got id %d", i, result.ID)
+		}
+	}
+}
+
+func TestNewUsersBatch(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("bcrypt_cost", "4")
+	ids := map[string]int{"a@example.com": 1, "b@example.com": 2, "c@example.com": 3}
+	uniqueViolation := &pq.Error{Code: "23505"}
+
+	t.Run("all or nothing inserts with one statement", func(t *testing.T) {
+		mock := mockDatabase(t)
+		mock.ExpectBegin()
+		expectTakenEmails(mock, []string{"a@example.com", "b@example.com"})
+		// Rows can be returned in any order, they are matched by email
+		expectInsertUsers(mock, "a@example.com", "b@example.com").WillReturnRows(insertedRows(ids, "b@example.com", "a@example.com"))
+		mock.ExpectCommit()
+
+		users := newBatchUsers(" A@Example.com", "b@example.com")
+		results, err := NewUsersBatch(ctx, users, BatchAllOrNothing)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		checkResultErrors(t, results)
+		if results[0].ID != 1 || results[1].ID != 2 {
+			t.Errorf("expected ids 1 and 2, got %+v", results)
+		}
+		if users[0].Email != " A@Example.com" || users[0].Password != "secret123" || users[0].ID != 0 {
+			t.Errorf("expected users of the caller not to be modified, got %+v", users[0])
+		}
+	})
+
+	t.Run("all or nothing rejects in-batch and registered duplicates", func(t *testing.T) {
+		mock := mockDatabase(t)
+		mock.ExpectBegin()
+		expectTakenEmails(mock, []string{"a@example.com", "c@example.com"}, "C@example.com")
+		mock.ExpectRollback()
+
+		results, err := NewUsersBatch(ctx, newBatchUsers("a@example.com", "A@EXAMPLE.COM", "c@example.com"), BatchAllOrNothing)
+		if !errors.Is(err, ErrValidation) {
+			t.Fatalf("expected ErrValidation, got %v", err)
+		}
+
+		checkResultErrors(t, results, 1, 2)
+		if !errors.Is(results[1].Errors, ErrEmailTaken) || !errors.Is(results[2].Errors, ErrEmailTaken) {
+			t.Errorf("expected ErrEmailTaken for the duplicates, got %+v", results)
+		}
+	})
+
+	t.Run("all or nothing reports emails taken by a concurrent signup", func(t *testing.T) {
+		mock := mockDatabase(t)
+		mock.ExpectBegin()
+		expectTakenEmails(mock, []string{"a@example.com", "b@example.com"})
+		expectInsertUsers(mock, "a@example.com", "b@example.com").WillReturnError(uniqueViolation)
+		mock.ExpectRollback()
+		expectTakenEmails(mock, []string{"a@example.com", "b@example.com"}, "b@example.com")
+
+		users := newBatchUsers("a@example.com", "b@example.com")
+		results, err := NewUsersBatch(ctx, users, BatchAllOrNothing)
+		if !errors.Is(err, ErrValidation) {
+			t.Fatalf("expected ErrValidation, got %v", err)
+		}
+
+		checkResultErrors(t, results, 1)
+		if results[0].ID != 0 {
+			t.Errorf("expected no user inserted, got %+v", results)
+		}
+		for _, user := range users {
+			if user.Password != "secret123" {
+				t.Errorf("expected the plaintext password to be kept for a retry, got %q", user.Password)
+			}
+		}
+	})
+
+	t.Run("partial inserts the valid users", func(t *testing.T) {
+		mock := mockDatabase(t)
+		mock.ExpectBegin()
+		expectTakenEmails(mock, []string{"a@example.com", "b@example.com"}, "b@example.com")
+		mock.ExpectExec(`^SAVEPOINT batch_users$`).WillReturnResult(sqlmock.NewResult(0, 0))
+		expectInsertUsers(mock, "a@example.com").WillReturnRows(insertedRows(ids, "a@example.com"))
+		mock.ExpectCommit()
+
+		results, err := NewUsersBatch(ctx, newBatchUsers("a@example.com", "b@example.com", "a@example.com", ""), BatchPartial)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		checkResultErrors(t, results, 1, 2, 3)
+		if results[0].ID != 1 {
+			t.Errorf("expected index 0 inserted with id 1, got %+v", results[0])
+		}
+	})
+
+	t.Run("partial falls back to savepoints per user", func(t *testing.T) {
+		mock := mockDatabase(t)
+		mock.ExpectBegin()
+		expectTakenEmails(mock, []string{"a@example.com", "b@example.com", "c@example.com"})
+		mock.ExpectExec(`^SAVEPOINT batch_users$`).WillReturnResult(sqlmock.NewResult(0, 0))
+		expectInsertUsers(mock, "a@example.com", "b@example.com", "c@example.com").WillReturnError(uniqueViolation)
+		mock.ExpectExec(`^ROLLBACK TO SAVEPOINT batch_users$`).WillReturnResult(sqlmock.NewResult(0, 0))
+		for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+			mock.ExpectExec(`^SAVEPOINT batch_user$`).WillReturnResult(sqlmock.NewResult(0, 0))
+			if email == "b@example.com" {
+				expectInsertUsers(mock, email).WillReturnError(uniqueViolation)
+				mock.ExpectExec(`^ROLLBACK TO SAVEPOINT batch_user$`).WillReturnResult(sqlmock.NewResult(0, 0))
+				continue
+			}
+			expectInsertUsers(mock, email).WillReturnRows(insertedRows(ids, email))
+			mock.ExpectExec(`^RELEASE SAVEPOINT batch_user$`).WillReturnResult(sqlmock.NewResult(0, 0))
+		}
+		mock.ExpectCommit()
+
+		results, err := NewUsersBatch(ctx, newBatchUsers("a@example.com", "b@example.com", "c@example.com"), BatchPartial)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		checkResultErrors(t, results, 1)
+		if results[0].ID != 1 || results[2].ID != 3 || !errors.Is(results[1].Errors, ErrEmailTaken) {
+			t.Errorf("expected users 1 and 3 inserted and index 1 taken, got %+v", results)
+		}
+	})
+}
+
+func TestValidateImportCSV(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("repeated in file", func(t *testing.T) {
+		mock := mockDatabase(t)
+		for i := 0; i < 2; i++ {
+			mock.ExpectQuery(`SELECT COUNT\(\*\) FROM "users"`).
+				WithArgs("a@example.com").
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		}
+
+		report, err := ValidateImportCSV(ctx, strings.NewReader("name,email,password\nUser,a@example.com,secret123\nUser, A@Example.com ,secret123\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if report.Valid != 1 || report.Invalid != 1 || !report.Rows[0].Valid || report.Rows[1].Valid {
+			t.Fatalf("expected only row 3 invalid, got %+v", report)
+		}
+		if rowErrors := report.Rows[1].Errors; len(rowErrors) != 1 || rowErrors[0].Message != "E-mail is repeated in row 2!" {
+			t.Errorf("expected repeated e-mail error, got %+v", rowErrors)
+		}
+	})
+
+	t.Run("registered in database", func(t *testing.T) {
+		mock := mockDatabase(t)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM "users"`).
+			WithArgs("taken@example.com").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+		report, err := ValidateImportCSV(ctx, strings.NewReader("email,name,password\ntaken@example.com,User,secret123\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if report.Invalid != 1 || !errors.Is(report.Rows[0].Errors, ErrEmailTaken) {
+			t.Errorf("expected ErrEmailTaken for row 2, got %+v", report)
+		}
+	})
+
+	t.Run("missing header column", func(t *testing.T) {
+		_, err := ValidateImportCSV(ctx, strings.NewReader("name,email\nUser,a@example.com\n"))
+		if err == nil || err.Error() != "csv header is missing column password" {
+			t.Errorf("expected missing password column error, got %v", err)
+		}
+	})
+}
@@ -3,107 +3,690 @@ package models
 import (
 	"boilerplate/database"
 	"boilerplate/models/schema"
+	u "boilerplate/utils"
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
-	"log"
+	"fmt"
+	"io"
+	"net/mail"
+	"os"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/lib/pq"
 	"github.com/volatiletech/null"
 	"github.com/volatiletech/sqlboiler/boil"
+	"github.com/volatiletech/sqlboiler/queries"
 	"github.com/volatiletech/sqlboiler/queries/qm"
+	"golang.org/x/crypto/bcrypt"
 )
 
-// validateUserData is a function to validate user before insert into database
-var validateUserData = func(user *schema.User) (bool, string) {
+// Errors returned by the user model functions, compare them with errors.Is
+var (
+	ErrUserNotFound     = errors.New("not found user")
+	ErrEmailTaken       = errors.New("There is already a registered user with this email, try another email!")
+	ErrPasswordMismatch = errors.New("password don't match")
+	ErrInvalidUserData  = errors.New("invalid user data")
+)
+
+// ErrPendingApproval is returned by Authenticate when the user was not approved by an admin yet
+var ErrPendingApproval = errors.New("user is pending approval")
+
+// Roles of users, new users get RoleUser and admins are promoted directly in database
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// ErrNotAdmin is returned by admin functions when the acting user is not an active admin
+var ErrNotAdmin = errors.New("user is not an admin")
+
+// ErrImmutableField is returned by update functions when a column configured as immutable would change
+var ErrImmutableField = errors.New("field cannot be changed")
+
+// Errors returned by the user model functions when their arguments are invalid, compare them with errors.Is
+var (
+	ErrUserAlreadyActive = errors.New("user is already active")
+	ErrNoChanges         = errors.New("no changes to update")
+	ErrColumnNotAllowed  = errors.New("column is not allowed")
+	ErrInvalidSort       = errors.New("users cannot be sorted by this column")
+	ErrInvalidOrder      = errors.New("order must be asc or desc")
+	ErrInvalidInterval   = errors.New("interval must be day, week or month")
+	ErrInvalidPeriod     = errors.New("invalid period")
+	ErrInvalidLimit      = errors.New("limit must be greater than zero")
+)
+
+// checkMutableColumns is a function to validate that none of columns is listed in "immutable_user_columns".
+// Every function changing user data on request must call it. Internal writes that keep the user data, like
+// Authenticate rehashing a legacy password to the same password and counting failed logins, are exempt
+var checkMutableColumns = func(columns ...string) error {
+	for _, immutable := range strings.Split(os.Getenv("immutable_user_columns"), ",") {
+		immutable = strings.TrimSpace(immutable)
+		for _, column := range columns {
+			if immutable != "" && immutable == column {
+				return fmt.Errorf("%s: %w", column, ErrImmutableField)
+			}
+		}
+	}
+
+	return nil
+}
+
+// normalizeEmail is a function to normalize an e-mail before store or compare it.
+// Surrounding whitespace is trimmed and by default the whole e-mail is lowercased, but when
+// "email_preserve_local_part" is enabled only the domain is lowercased, because the local-part is technically
+// case-sensitive (RFC 5321)
+var normalizeEmail = func(email string) string {
+	email = strings.TrimSpace(email)
+	if !u.GetEnvBool("email_preserve_local_part", false) {
+		return strings.ToLower(email)
+	}
+
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return email
+	}
+
+	return email[:at] + strings.ToLower(email[at:])
+}
+
+// emailEQ is a function to build a where clause matching the users with email, comparing them the same way
+// normalizeEmail does, so rows stored before normalization are matched too.
+// lower(email) is always compared, so the lookup uses the unique index on lower(email). That index also keeps
+// e-mails unique ignoring case when "email_preserve_local_part" is enabled, the local-part case only has to match
+// for the lookup. The stored email is split at its last @, like normalizeEmail, because the local part may have
+// a quoted @
+var emailEQ = func(email string) qm.QueryMod {
+	if u.GetEnvBool("email_preserve_local_part", false) {
+		return qm.Where("lower(email) = lower(?) AND left(email, length(email) - position('@' in reverse(email))) || lower(right(email, position('@' in reverse(email)))) = ?", email, normalizeEmail(email))
+	}
+
+	return qm.Where("lower(email) = lower(?)", email)
+}
+
+// ErrValidation is the error wrapped by ValidationErrors
+var ErrValidation = errors.New("validation failed")
+
+// FieldError is a struct that stores why a single field is invalid
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+	err     error  // sentinel error of the failure, if there is one
+}
+
+// ValidationErrors is a list of all invalid fields found in a validation
+type ValidationErrors []FieldError
+
+// Error is a function to join all field messages in a single error message
+func (v ValidationErrors) Error() string {
+	messages := make([]string, 0, len(v))
+	for _, fieldError := range v {
+		messages = append(messages, fieldError.Message)
+	}
+
+	return strings.Join(messages, " ")
+}
+
+// Is is a function to make errors.Is match ValidationErrors with ErrValidation, ErrInvalidUserData and the
+// error of any of its fields (e.g. ErrEmailTaken)
+func (v ValidationErrors) Is(target error) bool {
+	if target == ErrValidation || target == ErrInvalidUserData {
+		return true
+	}
+
+	for _, fieldError := range v {
+		if fieldError.err != nil && fieldError.err == target {
+			return true
+		}
+	}
+
+	return false
+}
+
+// emailTakenError is the FieldError of an email registered by another user, found by validation or by the
+// unique index, so both report it the same way
+var emailTakenError = FieldError{Field: "email", Message: ErrEmailTaken.Error(), err: ErrEmailTaken}
+
+// ValidatePassword is a function to validate a password against the password policy, returning ValidationErrors
+// with every failed rule or nil when the password is compliant
+var ValidatePassword = func(password string) error {
+	// Validate if the password is not empty and has more than 5 characters
+	if password == "" {
+		return ValidationErrors{{Field: "password", Message: "User password cannot be empty!"}}
+	} else if len(password) < 6 {
+		return ValidationErrors{{Field: "password", Message: "User password must be at least 6 characters!"}}
+	}
+
+	// Validation passed
+	return nil
+}
+
+// validateEmail is a function to validate if a normalized email is a single plain address, returning
+// ValidationErrors for the email field or nil when it is valid
+var validateEmail = func(email string) error {
+	if email == "" {
+		return ValidationErrors{{Field: "email", Message: "User e-mail cannot be empty!"}}
+	}
+
+	// Display names and comments are accepted by ParseAddress, but not as a stored email
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return ValidationErrors{{Field: "email", Message: "User e-mail is invalid!"}}
+	}
+
+	// Validation passed
+	return nil
+}
+
+// validateUserFields is a function to validate the fields of user that do not depend on database, returning
+// ValidationErrors with every invalid field or nil when validation passed
+var validateUserFields = func(user *schema.User) ValidationErrors {
+	validationErrors := make(ValidationErrors, 0)
+
 	// Validate if the user has a name
 	if user.Name == "" {
-		return false, "User name cannot be empty!"
+		validationErrors = append(validationErrors, FieldError{Field: "name", Message: "User name cannot be empty!"})
 	}
 
-	// Validate if the user has email
-	if user.Email == "" {
-		return false, "User e-mail cannot be empty!"
+	// Validate if the user has a valid email
+	var emailErrors ValidationErrors
+	if errors.As(validateEmail(user.Email), &emailErrors) {
+		validationErrors = append(validationErrors, emailErrors...)
 	}
 
-	// Validate if the user has password and more than 5 characters
-	if user.Password == "" {
-		return false, "User password cannot be empty!"
-	} else if len(user.Password) < 6 {
-		return false, "User password must be at least 6 characters!"
+	// Validate if the user password follows the password policy
+	var passwordErrors ValidationErrors
+	if errors.As(ValidatePassword(user.Password), &passwordErrors) {
+		validationErrors = append(validationErrors, passwordErrors...)
 	}
 
-	// Validate if exist registered user with same email
-	existUser, _ := schema.Users(schema.UserWhere.Email.EQ(user.Email)).Exists(context.Background(), database.InstanceDB)
-	if existUser {
-		return false, "There is already a registered user with this email, try another email!"
+	if len(validationErrors) > 0 {
+		return validationErrors
+	}
+
+	// Validation passed
+	return nil
+}
+
+// validateUserData is a function to validate user before insert into database, returning ValidationErrors
+// with every invalid field or nil when validation passed
+var validateUserData = func(ctx context.Context, exec boil.ContextExecutor, user *schema.User) error {
+	validationErrors := validateUserFields(user)
+
+	// Validate if exist registered user with same email, only when the email itself is valid
+	if validateEmail(user.Email) == nil {
+		existUser, err := schema.Users(emailEQ(user.Email), schema.UserWhere.DeletedAt.IsNull()).Exists(ctx, exec)
+		if err != nil {
+			logger.ErrorContext(ctx, "check e-mail of new user", "error", err, "email", user.Email)
+			return err
+		}
+		if existUser {
+			validationErrors = append(validationErrors, emailTakenError)
+		}
+	}
+
+	if len(validationErrors) > 0 {
+		return validationErrors
 	}
 
 	// Validation passed
-	return true, ""
+	return nil
 }
 
-// Authenticate is a function to validate user password, finding by email
-var Authenticate = func(email, password string) (bool, error) {
-	user, err := schema.Users(qm.Select("password"), qm.Where("email=?", email)).One(context.Background(), database.InstanceDB)
+// hashPassword is a function to hash a plaintext password with bcrypt, using the cost from "bcrypt_cost"
+var hashPassword = func(password string) (string, error) {
+	cost := u.GetEnvInt("bcrypt_cost", bcrypt.DefaultCost)
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		cost = bcrypt.DefaultCost
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
+
+// isPasswordHashed is a function to check if a stored password is a bcrypt hash, rows created before passwords
+// were hashed still store the plaintext
+var isPasswordHashed = func(storedPassword string) bool {
+	_, err := bcrypt.Cost([]byte(storedPassword))
+	return err == nil
+}
+
+// isUniqueViolation is a function to check if err was caused by a unique constraint violation in database
+var isUniqueViolation = func(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505" // unique_violation
+}
+
+// findUser is a function to find a user that is not soft deleted by id, selecting only columns when they are given
+var findUser = func(ctx context.Context, exec boil.ContextExecutor, userID int, columns ...string) (*schema.User, error) {
+	mods := []qm.QueryMod{schema.UserWhere.ID.EQ(userID), schema.UserWhere.DeletedAt.IsNull()}
+	if len(columns) > 0 {
+		mods = append(mods, qm.Select(columns...))
+	}
+
+	return schema.Users(mods...).One(ctx, exec)
+}
+
+// checkEmailTakenByOther is a function to validate that no other registered user than the one with id equal to
+// userID has email, returning the same ValidationErrors of NewUser when it is taken
+var checkEmailTakenByOther = func(ctx context.Context, exec boil.ContextExecutor, email string, userID int) error {
+	existUser, err := schema.Users(emailEQ(email), schema.UserWhere.ID.NEQ(userID), schema.UserWhere.DeletedAt.IsNull()).Exists(ctx, exec)
+	if err != nil {
+		logger.ErrorContext(ctx, "check e-mail taken by other user", "error", err, "user_id", userID, "email", email)
+		return err
+	}
+
+	if existUser {
+		return ValidationErrors{emailTakenError}
+	}
+
+	return nil
+}
+
+// checkAdmin is a function to validate that the user with id equal to adminID is an active admin
+var checkAdmin = func(ctx context.Context, exec boil.ContextExecutor, adminID int) error {
+	admin, err := findUser(ctx, exec, adminID, "id", "active", "role")
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotAdmin
+		}
+
+		logger.ErrorContext(ctx, "find admin", "error", err, "admin_id", adminID)
+		return err
+	}
+
+	if !admin.Active || admin.Role != RoleAdmin {
+		return ErrNotAdmin
+	}
+
+	return nil
+}
+
+// ErrAccountLocked is returned by Authenticate while the user is locked out after too many failed logins
+var ErrAccountLocked = errors.New("account is locked, try again later")
+
+// Account lockout policy of Authenticate, after MaxFailedLoginAttempts password mismatches in a row the user is
+// locked for LockoutDuration
+var (
+	MaxFailedLoginAttempts = 5
+	LockoutDuration        = 15 * time.Minute
+)
+
+// isAccountLocked is a function to check if user is locked out at now
+var isAccountLocked = func(user *schema.User, now time.Time) bool {
+	return user.LockedUntil.Valid && user.LockedUntil.Time.After(now)
+}
+
+// lockoutFor is a function to return until when a user with attempts failed logins in a row must be locked,
+// or an invalid time when the user must not be locked
+var lockoutFor = func(attempts int, now time.Time) null.Time {
+	if attempts < MaxFailedLoginAttempts {
+		return null.Time{}
+	}
+
+	return null.TimeFrom(now.Add(LockoutDuration))
+}
+
+// registerFailedLogin is a function to count a password mismatch of the user with id equal to userID, locking the
+// user when needed. The counter is incremented by the database, so concurrent attempts are all counted, and it
+// restarts when the user is locked, so the user has all the attempts again after the lock expires
+var registerFailedLogin = func(ctx context.Context, userID int) error {
+	var attempts int
+	err := database.InstanceDB.QueryRowContext(ctx,
+		"UPDATE users SET failed_login_attempts = failed_login_attempts + 1 WHERE id = $1 RETURNING failed_login_attempts",
+		userID,
+	).Scan(&attempts)
+	if err != nil {
+		logger.ErrorContext(ctx, "count failed login", "error", err, "user_id", userID)
+		return err
+	}
+
+	lockedUntil := lockoutFor(attempts, time.Now())
+	if !lockedUntil.Valid {
+		return nil
+	}
+
+	_, err = schema.Users(schema.UserWhere.ID.EQ(userID)).UpdateAll(ctx, database.InstanceDB, schema.M{
+		"failed_login_attempts": 0,
+		"locked_until":          lockedUntil,
+	})
+	if err != nil {
+		logger.ErrorContext(ctx, "lock user", "error", err, "user_id", userID)
+		return err
+	}
+
+	logger.WarnContext(ctx, "user locked after failed logins", "user_id", userID, "locked_until", lockedUntil.Time)
+
+	return nil
+}
+
+// Authenticate is a function to validate user password, finding by email.
+// Locked out users are rejected with ErrAccountLocked before comparing the password
+var Authenticate = func(ctx context.Context, email, password string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	email = normalizeEmail(email)
+
+	user, err := schema.Users(qm.Select("id", "password", "active", "failed_login_attempts", "locked_until"), emailEQ(email), schema.UserWhere.DeletedAt.IsNull()).One(ctx, database.InstanceDB)
 	if err != nil {
-		if err.Error() == "sql: no rows in result set" {
-			return false, errors.New("not found user by e-mail")
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, fmt.Errorf("%w by e-mail", ErrUserNotFound)
 		}
 
-		log.Println(err)
+		logger.ErrorContext(ctx, "find user by e-mail", "error", err, "email", email)
 		return false, err
 	}
 
-	if user == nil {
-		return false, errors.New("not found user by e-mail")
+	if isAccountLocked(user, time.Now()) {
+		return false, ErrAccountLocked
+	}
+
+	if isPasswordHashed(user.Password) {
+		if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+			if err := registerFailedLogin(ctx, user.ID); err != nil {
+				return false, err
+			}
+			return false, ErrPasswordMismatch
+		}
+	} else {
+		// Legacy plaintext row, compare it and rehash the password now that it is known to be right
+		if subtle.ConstantTimeCompare([]byte(password), []byte(user.Password)) != 1 {
+			if err := registerFailedLogin(ctx, user.ID); err != nil {
+				return false, err
+			}
+			return false, ErrPasswordMismatch
+		}
+
+		hash, err := hashPassword(password)
+		if err != nil {
+			logger.ErrorContext(ctx, "hash password", "error", err, "user_id", user.ID)
+			return false, err
+		}
+
+		user.Password = hash
+		if _, err := user.Update(ctx, database.InstanceDB, boil.Whitelist("password")); err != nil {
+			logger.ErrorContext(ctx, "rehash legacy password", "error", err, "user_id", user.ID)
+			return false, err
+		}
+	}
+
+	// Successful login restarts the failed attempts and clears an expired lock
+	if user.FailedLoginAttempts != 0 || user.LockedUntil.Valid {
+		user.FailedLoginAttempts = 0
+		user.LockedUntil = null.Time{}
+		if _, err := user.Update(ctx, database.InstanceDB, boil.Whitelist("failed_login_attempts", "locked_until")); err != nil {
+			logger.ErrorContext(ctx, "reset failed logins", "error", err, "user_id", user.ID)
+			return false, err
+		}
 	}
 
-	if password != user.Password {
-		return false, errors.New("password don't match")
+	if !user.Active {
+		return false, ErrPendingApproval
 	}
 
 	return true, nil
 }
 
 // NewUser is a function to insert a single new user into database
-var NewUser = func(user *schema.User) (*schema.User, error) {
+var NewUser = func(ctx context.Context, user *schema.User) (*schema.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	user.Email = normalizeEmail(user.Email)
+
+	// Duplicate email check and insert run in the same transaction
+	tx, err := database.InstanceDB.BeginTx(ctx, nil)
+	if err != nil {
+		logger.ErrorContext(ctx, "begin create user transaction", "error", err, "email", user.Email)
+		return nil, err
+	}
+	defer tx.Rollback() // no effect after commit
+
 	// Validate user data to insert
-	if valid, messageError := validateUserData(user); !valid {
-		return nil, errors.New(messageError)
+	if err := validateUserData(ctx, tx, user); err != nil {
+		return nil, err
+	}
+
+	// Hash password after validation, because the password policy applies to the plaintext
+	hash, err := hashPassword(user.Password)
+	if err != nil {
+		logger.ErrorContext(ctx, "hash password", "error", err, "email", user.Email)
+		return nil, err
 	}
+	user.Password = hash
 
-	// Insert user into database
-	err := user.Insert(context.Background(), database.InstanceDB, boil.Infer())
+	// Run before create hooks, any error aborts the insert
+	if err := runBeforeUserHooks(ctx, BeforeCreate, user); err != nil {
+		return nil, err
+	}
+
+	// New users start inactive when admin approval is required
+	user.Active = !u.GetEnvBool("require_approval", false)
+
+	// Insert user into database, active is always inserted because its database default is true
+	err = user.Insert(ctx, tx, boil.Greylist("active"))
 	if err != nil {
-		log.Println(err)
+		// A concurrent signup with the same email inserted first
+		if isUniqueViolation(err) {
+			return nil, ValidationErrors{emailTakenError}
+		}
+
+		logger.ErrorContext(ctx, "insert user", "error", err, "email", user.Email)
 		return nil, err
 	}
 
+	// Insert fills the id returned by database, without it the created user cannot be found safely
+	if user.ID == 0 {
+		return nil, errors.New("database did not return the id of the created user")
+	}
+
 	// Get new user created
-	userCreated, err := schema.Users(qm.SQL("select id, name, email from users order by id desc")).One(context.Background(), database.InstanceDB)
+	userCreated, err := schema.FindUser(ctx, tx, user.ID, "id", "name", "email") // return only id, name and email columns
 	if err != nil {
-		log.Println(err)
+		logger.ErrorContext(ctx, "find created user", "error", err, "user_id", user.ID)
 		return nil, err
 	}
 
+	if err := tx.Commit(); err != nil {
+		logger.ErrorContext(ctx, "commit created user", "error", err, "user_id", user.ID)
+		return nil, err
+	}
+
+	runAfterUserHooks(ctx, AfterCreate, userCreated)
+
 	return userCreated, nil
 }
 
 // GetAllUsers is a function to return all users registered in database
-var GetAllUsers = func() ([]*schema.User, error) {
-	allUsers, err := schema.Users().All(context.Background(), database.InstanceDB)
+var GetAllUsers = func(ctx context.Context) ([]*schema.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	allUsers, err := schema.Users(schema.UserWhere.DeletedAt.IsNull()).All(ctx, database.InstanceDB)
 	if err != nil {
-		log.Println(err)
+		logger.ErrorContext(ctx, "get all users", "error", err)
 		return nil, err
 	}
 
 	return allUsers, nil
 }
 
+// Limits of the number of users returned by GetUsersPaginated
+const (
+	defaultUsersPageLimit = 20
+	maxUsersPageLimit     = 100
+)
+
+// usersSortColumns is the allowlist of columns users can be sorted by
+var usersSortColumns = map[string]bool{"id": true, "name": true, "email": true, "created_at": true}
+
+// GetUsersPaginated is a function to return a page of users sorted by sortBy in order ("asc" or "desc"), and the
+// total number of users. An offset past the end returns an empty page with the real total
+var GetUsersPaginated = func(ctx context.Context, limit, offset int, sortBy, order string) ([]*schema.User, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	if limit <= 0 {
+		limit = defaultUsersPageLimit
+	} else if limit > maxUsersPageLimit {
+		limit = maxUsersPageLimit
+	}
+
+	if offset < 0 {
+		offset = 0
+	}
+
+	// sortBy and order are part of the query, so only allowlisted values are accepted
+	if sortBy == "" {
+		sortBy = "id"
+	} else if !usersSortColumns[sortBy] {
+		return nil, 0, fmt.Errorf("%s: %w", sortBy, ErrInvalidSort)
+	}
+
+	order = strings.ToUpper(order)
+	if order == "" {
+		order = "ASC"
+	} else if order != "ASC" && order != "DESC" {
+		return nil, 0, ErrInvalidOrder
+	}
+
+	total, err := schema.Users(schema.UserWhere.DeletedAt.IsNull()).Count(ctx, database.InstanceDB)
+	if err != nil {
+		logger.ErrorContext(ctx, "count users", "error", err)
+		return nil, 0, err
+	}
+
+	users, err := schema.Users(
+		qm.Select("id", "name", "email", "active", "created_at"), // return only safe columns
+		schema.UserWhere.DeletedAt.IsNull(),
+		qm.OrderBy(fmt.Sprintf("%s %s, id %s", sortBy, order, order)),
+		qm.Limit(limit),
+		qm.Offset(offset),
+	).All(ctx, database.InstanceDB)
+	if err != nil {
+		logger.ErrorContext(ctx, "get users page", "error", err)
+		return nil, 0, err
+	}
+
+	return users, total, nil
+}
+
+// UserFilter is a struct with the optional conditions of SearchUsers, empty fields are not used
+type UserFilter struct {
+	NameContains  string     // case-insensitive part of the name
+	EmailContains string     // case-insensitive part of the email
+	CreatedAfter  *time.Time // only users created after this time
+	Limit         int
+	Offset        int
+}
+
+// likeEscaper escapes the LIKE wildcards, so they are matched literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// userFilterMods is a function to build the where clauses of filter, combined with AND
+var userFilterMods = func(filter UserFilter) []qm.QueryMod {
+	mods := []qm.QueryMod{schema.UserWhere.DeletedAt.IsNull()}
+
+	if filter.NameContains != "" {
+		mods = append(mods, qm.Where("name ILIKE ?", "%"+likeEscaper.Replace(filter.NameContains)+"%"))
+	}
+	if filter.EmailContains != "" {
+		mods = append(mods, qm.Where("email ILIKE ?", "%"+likeEscaper.Replace(filter.EmailContains)+"%"))
+	}
+	if filter.CreatedAfter != nil {
+		mods = append(mods, schema.UserWhere.CreatedAt.GT(*filter.CreatedAfter))
+	}
+
+	return mods
+}
+
+// SearchUsers is a function to return a page of users matching all the conditions of filter, ordered by id, and the
+// total number of matching users. An empty filter matches all users
+var SearchUsers = func(ctx context.Context, filter UserFilter) ([]*schema.User, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	if filter.Limit <= 0 {
+		filter.Limit = defaultUsersPageLimit
+	} else if filter.Limit > maxUsersPageLimit {
+		filter.Limit = maxUsersPageLimit
+	}
+
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+
+	mods := userFilterMods(filter)
+
+	total, err := schema.Users(mods...).Count(ctx, database.InstanceDB)
+	if err != nil {
+		logger.ErrorContext(ctx, "count searched users", "error", err)
+		return nil, 0, err
+	}
+
+	users, err := schema.Users(append(mods,
+		qm.Select("id", "name", "email", "active", "created_at"), // return only safe columns
+		qm.OrderBy("id"),
+		qm.Limit(filter.Limit),
+		qm.Offset(filter.Offset),
+	)...).All(ctx, database.InstanceDB)
+	if err != nil {
+		logger.ErrorContext(ctx, "search users", "error", err)
+		return nil, 0, err
+	}
+
+	return users, total, nil
+}
+
 // GetUserByID is a function to return a single user by ID
-var GetUserByID = func(userId int) (*schema.User, error) {
-	user, err := schema.FindUser(context.Background(), database.InstanceDB, userId, "id", "name", "email") // return only id, name and email columns
+var GetUserByID = func(ctx context.Context, userId int) (*schema.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	user, err := findUser(ctx, database.InstanceDB, userId, "id", "name", "email") // return only id, name and email columns
 	if err != nil {
-		log.Println(err)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+
+		logger.ErrorContext(ctx, "find user", "error", err, "user_id", userId)
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// GetUserByEmail is a function to return a single user by email
+var GetUserByEmail = func(ctx context.Context, email string) (*schema.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	user, err := schema.Users(qm.Select("id", "name", "email", "active", "role"), emailEQ(normalizeEmail(email)), schema.UserWhere.DeletedAt.IsNull()).One(ctx, database.InstanceDB)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+
+		logger.ErrorContext(ctx, "find user by e-mail", "error", err, "email", email)
 		return nil, err
 	}
 
@@ -111,10 +694,18 @@ var GetUserByID = func(userId int) (*schema.User, error) {
 }
 
 // GetUserByToken is a function to return a single user by refresh_token
-var GetUserByToken = func(refreshToken string) (*schema.User, error) {
-	user, err := schema.Users(schema.UserWhere.RefreshToken.EQ(null.StringFrom(refreshToken))).One(context.Background(), database.InstanceDB)
+var GetUserByToken = func(ctx context.Context, refreshToken string) (*schema.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	user, err := schema.Users(schema.UserWhere.RefreshToken.EQ(null.StringFrom(refreshToken)), schema.UserWhere.DeletedAt.IsNull()).One(ctx, database.InstanceDB)
 	if err != nil {
-		log.Println(err)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+
+		logger.ErrorContext(ctx, "find user by refresh token", "error", err)
 		return nil, err
 	}
 
@@ -122,17 +713,55 @@ var GetUserByToken = func(refreshToken string) (*schema.User, error) {
 }
 
 // UpdateUser is a function to update data from a single user
-var UpdateUser = func(userToUpdate *schema.User) (int64, error) {
+var UpdateUser = func(ctx context.Context, userToUpdate *schema.User) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	userToUpdate.Email = normalizeEmail(userToUpdate.Email)
+
 	// Validate if exist user with id equal to userId
-	user, _ := schema.FindUser(context.Background(), database.InstanceDB, userToUpdate.ID)
-	if user == nil {
-		return 0, errors.New("not found user")
+	user, err := findUser(ctx, database.InstanceDB, userToUpdate.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrUserNotFound
+		}
+
+		logger.ErrorContext(ctx, "find user", "error", err, "user_id", userToUpdate.ID)
+		return 0, err
+	}
+
+	// Validate if changed columns are allowed to change
+	changedColumns := make([]string, 0)
+	if userToUpdate.Name != user.Name {
+		changedColumns = append(changedColumns, "name")
+	}
+	if userToUpdate.Email != user.Email {
+		changedColumns = append(changedColumns, "email")
+	}
+	if err := checkMutableColumns(changedColumns...); err != nil {
+		return 0, err
+	}
+
+	if userToUpdate.Email != user.Email {
+		if err := validateEmail(userToUpdate.Email); err != nil {
+			return 0, err
+		}
+
+		// Validate if exist another registered user with same email
+		if err := checkEmailTakenByOther(ctx, database.InstanceDB, userToUpdate.Email, userToUpdate.ID); err != nil {
+			return 0, err
+		}
 	}
 
 	// Update user with userToUpdate data
-	rowsAff, err := userToUpdate.Update(context.Background(), database.InstanceDB, boil.Whitelist("name", "email")) // only update name and email columns
+	rowsAff, err := userToUpdate.Update(ctx, database.InstanceDB, boil.Whitelist("name", "email")) // only update name and email columns
 	if err != nil {
-		log.Println(err)
+		if isUniqueViolation(err) {
+			return 0, ValidationErrors{emailTakenError}
+		}
+
+		logger.ErrorContext(ctx, "update user", "error", err, "user_id", userToUpdate.ID)
 		return 0, err
 	}
 
@@ -141,20 +770,32 @@ var UpdateUser = func(userToUpdate *schema.User) (int64, error) {
 		return 0, errors.New("no affected lines")
 	}
 
+	runAfterUserHooks(ctx, AfterUpdate, userToUpdate)
+
 	// Return affected rows with update
 	return rowsAff, nil
 }
 
 // UpdateRefreshTokenByEmail is a function to update refresh token from a single user by email
-var UpdateRefreshTokenByEmail = func(email string, refreshToken string) (int64, error) {
+var UpdateRefreshTokenByEmail = func(ctx context.Context, email string, refreshToken string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	email = normalizeEmail(email)
+
 	// Validate if exist user with email
-	user, err := schema.Users(qm.Select("id"), qm.Where("email=?", email)).One(context.Background(), database.InstanceDB)
+	user, err := schema.Users(qm.Select("id"), emailEQ(email), schema.UserWhere.DeletedAt.IsNull()).One(ctx, database.InstanceDB)
 	if err != nil {
-		if err.Error() == "sql: no rows in result set" {
-			return 0, errors.New("not found user by e-mail")
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("%w by e-mail", ErrUserNotFound)
 		}
 
-		log.Println(err)
+		logger.ErrorContext(ctx, "find user by e-mail", "error", err, "email", email)
+		return 0, err
+	}
+
+	if err := checkMutableColumns("refresh_token"); err != nil {
 		return 0, err
 	}
 
@@ -162,9 +803,9 @@ var UpdateRefreshTokenByEmail = func(email string, refreshToken string) (int64,
 	user.RefreshToken = null.StringFrom(refreshToken)
 
 	// Update refresh token user
-	rowsAff, err := user.Update(context.Background(), database.InstanceDB, boil.Whitelist("refresh_token")) // only update refres_token column
+	rowsAff, err := user.Update(ctx, database.InstanceDB, boil.Whitelist("refresh_token")) // only update refres_token column
 	if err != nil {
-		log.Println(err)
+		logger.ErrorContext(ctx, "update refresh token", "error", err, "user_id", user.ID)
 		return 0, err
 	}
 
@@ -177,18 +818,223 @@ var UpdateRefreshTokenByEmail = func(email string, refreshToken string) (int64,
 	return rowsAff, nil
 }
 
-// DeleteUserByID is a function to delete a single user
-var DeleteUserByID = func(userId int) (int64, error) {
-	// Validate if exist user with id equal to userId
-	user, _ := schema.FindUser(context.Background(), database.InstanceDB, userId)
-	if user == nil {
-		return 0, errors.New("not found user")
+// Errors returned by ResetPassword, compare them with errors.Is
+var (
+	ErrInvalidResetToken = errors.New("password reset token is invalid")
+	ErrResetTokenExpired = errors.New("password reset token has expired")
+)
+
+// PasswordResetTokenTTL is how long a token returned by GeneratePasswordResetToken can be used
+var PasswordResetTokenTTL = time.Hour
+
+// hashResetToken is a function to hash a password reset token before store or compare it, so the tokens
+// cannot be used by whoever reads the users table
+var hashResetToken = func(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// GeneratePasswordResetToken is a function to create a random password reset token for the user with email,
+// valid for PasswordResetTokenTTL and replacing any previous token of the user.
+// When there is no user with email an empty token and a nil error are returned, so callers answer the same way
+// for registered and unregistered e-mails and only send the token when it is not empty
+var GeneratePasswordResetToken = func(ctx context.Context, email string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	email = normalizeEmail(email)
+
+	// Validate if exist user with email
+	user, err := schema.Users(qm.Select("id"), emailEQ(email), schema.UserWhere.DeletedAt.IsNull()).One(ctx, database.InstanceDB)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+
+		logger.ErrorContext(ctx, "find user by e-mail", "error", err, "email", email)
+		return "", err
+	}
+
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		logger.ErrorContext(ctx, "generate password reset token", "error", err, "user_id", user.ID)
+		return "", err
+	}
+	token := hex.EncodeToString(randomBytes)
+
+	// Store only the hash of the token with its expiry
+	user.ResetToken = null.StringFrom(hashResetToken(token))
+	user.ResetTokenExpiresAt = null.TimeFrom(time.Now().Add(PasswordResetTokenTTL))
+	_, err = user.Update(ctx, database.InstanceDB, boil.Whitelist("reset_token", "reset_token_expires_at")) // only update reset token columns
+	if err != nil {
+		logger.ErrorContext(ctx, "store password reset token", "error", err, "user_id", user.ID)
+		return "", err
+	}
+
+	return token, nil
+}
+
+// ResetPassword is a function to change the password of the user owning a token generated by
+// GeneratePasswordResetToken. The token is cleared with the password change, so it can be used only once, and
+// the refresh token is cleared too, signing the user out of existing sessions
+var ResetPassword = func(ctx context.Context, token, newPassword string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if token == "" {
+		return ErrInvalidResetToken
+	}
+
+	if err := ValidatePassword(newPassword); err != nil {
+		return err
+	}
+
+	tokenHash := null.StringFrom(hashResetToken(token))
+
+	// Validate if exist user with the token
+	user, err := schema.Users(qm.Select("id", "reset_token_expires_at"), schema.UserWhere.ResetToken.EQ(tokenHash), schema.UserWhere.DeletedAt.IsNull()).One(ctx, database.InstanceDB)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInvalidResetToken
+		}
+
+		logger.ErrorContext(ctx, "find user by password reset token", "error", err)
+		return err
+	}
+
+	if !user.ResetTokenExpiresAt.Valid || !user.ResetTokenExpiresAt.Time.After(time.Now()) {
+		return ErrResetTokenExpired
+	}
+
+	if err := checkMutableColumns("password"); err != nil {
+		return err
+	}
+
+	hashedPassword, err := hashPassword(newPassword)
+	if err != nil {
+		logger.ErrorContext(ctx, "hash password", "error", err, "user_id", user.ID)
+		return err
+	}
+
+	// Matching the token again in the update makes a concurrent reset with the same token affect no rows
+	rowsAff, err := schema.Users(schema.UserWhere.ID.EQ(user.ID), schema.UserWhere.ResetToken.EQ(tokenHash)).UpdateAll(ctx, database.InstanceDB, schema.M{
+		"password":               hashedPassword,
+		"refresh_token":          nil,
+		"reset_token":            nil,
+		"reset_token_expires_at": nil,
+	})
+	if err != nil {
+		logger.ErrorContext(ctx, "reset password", "error", err, "user_id", user.ID)
+		return err
+	}
+
+	if rowsAff == 0 {
+		return ErrInvalidResetToken
+	}
+
+	return nil
+}
+
+// DeleteUserByID is a function to soft delete a single user, keeping the row with deleted_at set so it can be
+// restored by RestoreUserByID. Soft deleted users are ignored by all the other user functions
+var DeleteUserByID = func(ctx context.Context, userId int) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	// Validate if exist user with id equal to userId
+	user, err := findUser(ctx, database.InstanceDB, userId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrUserNotFound
+		}
+
+		logger.ErrorContext(ctx, "find user", "error", err, "user_id", userId)
+		return 0, err
+	}
+
+	// Set deleted_at of user with id equal to userId
+	user.DeletedAt = null.TimeFrom(time.Now())
+	rowsAff, err := user.Update(ctx, database.InstanceDB, boil.Whitelist("deleted_at")) // only update deleted_at column
+	if err != nil {
+		logger.ErrorContext(ctx, "soft delete user", "error", err, "user_id", userId)
+		return 0, err
+	}
+
+	// Validate if there were lines affected
+	if rowsAff < 0 {
+		return 0, errors.New("no affected lines")
+	}
+
+	runAfterUserHooks(ctx, AfterDelete, user)
+
+	// Return affected rows with delete
+	return rowsAff, nil
+}
+
+// RestoreUserByID is a function to restore a user soft deleted by DeleteUserByID
+var RestoreUserByID = func(ctx context.Context, userId int) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	// Validate if exist soft deleted user with id equal to userId
+	user, err := schema.Users(qm.Select("id", "name", "email"), schema.UserWhere.ID.EQ(userId), schema.UserWhere.DeletedAt.IsNotNull()).One(ctx, database.InstanceDB)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrUserNotFound
+		}
+
+		logger.ErrorContext(ctx, "find user", "error", err, "user_id", userId)
+		return 0, err
+	}
+
+	// Validate if the email was not registered by another user after the delete
+	if err := checkEmailTakenByOther(ctx, database.InstanceDB, user.Email, userId); err != nil {
+		return 0, err
+	}
+
+	// Clear deleted_at of user with id equal to userId
+	user.DeletedAt = null.Time{}
+	rowsAff, err := user.Update(ctx, database.InstanceDB, boil.Whitelist("deleted_at")) // only update deleted_at column
+	if err != nil {
+		if isUniqueViolation(err) {
+			return 0, ValidationErrors{emailTakenError}
+		}
+
+		logger.ErrorContext(ctx, "restore user", "error", err, "user_id", userId)
+		return 0, err
+	}
+
+	runAfterUserHooks(ctx, AfterUpdate, user)
+
+	// Return affected rows with update
+	return rowsAff, nil
+}
+
+// HardDeleteUserByID is a function to permanently delete a single user, soft deleted or not
+var HardDeleteUserByID = func(ctx context.Context, userId int) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	// Validate if exist user with id equal to userId
+	user, err := schema.FindUser(ctx, database.InstanceDB, userId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrUserNotFound
+		}
+
+		logger.ErrorContext(ctx, "find user", "error", err, "user_id", userId)
+		return 0, err
 	}
 
 	// Delete user with id equal to userId
-	rowsAff, err := user.Delete(context.Background(), database.InstanceDB)
+	rowsAff, err := user.Delete(ctx, database.InstanceDB)
 	if err != nil {
-		log.Println(err)
+		logger.ErrorContext(ctx, "delete user", "error", err, "user_id", userId)
 		return 0, err
 	}
 
@@ -197,6 +1043,589 @@ var DeleteUserByID = func(userId int) (int64, error) {
 		return 0, errors.New("no affected lines")
 	}
 
+	if !user.DeletedAt.Valid {
+		runAfterUserHooks(ctx, AfterDelete, user)
+	}
+
+	// Return affected rows with delete
+	return rowsAff, nil
+}
+
+// DeleteUsersInBatches is a function to permanently delete all users matching filters, batchSize users at a time.
+// Batches are selected with a keyset cursor on id instead of an offset, so deleting a batch never shifts
+// the next one and every matching user is processed exactly once.
+// Filters must be where mods only (like schema.UserWhere or qm.Where), they are grouped in parentheses after the
+// cursor, and mods like qm.OrderBy, qm.Limit or qm.Offset would break the cursor
+var DeleteUsersInBatches = func(ctx context.Context, batchSize int, filters ...qm.QueryMod) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	if batchSize <= 0 {
+		return 0, errors.New("batch size must be greater than zero")
+	}
+
+	var deleted int64
+	lastID := 0
+
+	for {
+		// Get next batch of users after the last deleted id
+		batchMods := []qm.QueryMod{
+			qm.Select("id"),
+			schema.UserWhere.ID.GT(lastID),
+			qm.OrderBy("id"),
+			qm.Limit(batchSize),
+		}
+		if len(filters) > 0 {
+			batchMods = append(batchMods, qm.Expr(filters...))
+		}
+
+		users, err := schema.Users(batchMods...).All(ctx, database.InstanceDB)
+		if err != nil {
+			logger.ErrorContext(ctx, "get users batch to delete", "error", err)
+			return deleted, err
+		}
+
+		if len(users) == 0 {
+			break
+		}
+
+		// Delete users of this batch
+		rowsAff, err := users.DeleteAll(ctx, database.InstanceDB)
+		if err != nil {
+			logger.ErrorContext(ctx, "delete users batch", "error", err)
+			return deleted, err
+		}
+
+		for _, user := range users {
+			runAfterUserHooks(ctx, AfterDelete, user)
+		}
+
+		deleted += rowsAff
+		lastID = users[len(users)-1].ID
+
+		if len(users) < batchSize {
+			break
+		}
+	}
+
 	// Return affected rows with delete
+	return deleted, nil
+}
+
+// TimeBucket is a struct that stores the number of users created inside a time interval
+type TimeBucket struct {
+	Start time.Time `boil:"bucket" json:"start"`
+	Count int64     `boil:"count" json:"count"`
+}
+
+// signupIntervals is the allowlist of intervals accepted by SignupsOverTime
+var signupIntervals = map[string]bool{"day": true, "week": true, "month": true}
+
+// maxSignupBuckets is the maximum number of intervals returned by SignupsOverTime
+const maxSignupBuckets = 1000
+
+// truncateToInterval is a function to return the start of the day, week (starting on monday) or month of t in UTC,
+// the same as date_trunc of postgres
+var truncateToInterval = func(t time.Time, interval string) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+
+	switch interval {
+	case "week":
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case "month":
+		return day.AddDate(0, 0, 1-day.Day())
+	default:
+		return day
+	}
+}
+
+// signupBuckets is a function to return every interval between from and to with its count in counts (indexed by
+// the unix time of the interval start), intervals without count have count zero
+var signupBuckets = func(from, to time.Time, interval string, counts map[int64]int64) ([]TimeBucket, error) {
+	buckets := make([]TimeBucket, 0)
+
+	for start := truncateToInterval(from, interval); !start.After(to); {
+		if len(buckets) == maxSignupBuckets {
+			return nil, fmt.Errorf("%w, it cannot have more than %d intervals", ErrInvalidPeriod, maxSignupBuckets)
+		}
+
+		buckets = append(buckets, TimeBucket{Start: start, Count: counts[start.Unix()]})
+
+		switch interval {
+		case "week":
+			start = start.AddDate(0, 0, 7)
+		case "month":
+			start = start.AddDate(0, 1, 0)
+		default:
+			start = start.AddDate(0, 0, 1)
+		}
+	}
+
+	return buckets, nil
+}
+
+// SignupsOverTime is a function to count users created between from and to, grouped by day, week or month in UTC.
+// Every interval inside the period is returned, intervals without signups have count zero. Periods with more than
+// maxSignupBuckets intervals are rejected
+var SignupsOverTime = func(ctx context.Context, from, to time.Time, interval string) ([]TimeBucket, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if !signupIntervals[interval] {
+		return nil, ErrInvalidInterval
+	}
+
+	if to.Before(from) {
+		return nil, fmt.Errorf("%w, end must be after start", ErrInvalidPeriod)
+	}
+
+	// Validate the period size before querying
+	if _, err := signupBuckets(from, to, interval, nil); err != nil {
+		return nil, err
+	}
+
+	// Only intervals with signups are returned by database
+	signups := make([]TimeBucket, 0)
+	err := queries.Raw(`
+		SELECT date_trunc($1, u.created_at AT TIME ZONE 'UTC') AS bucket, COUNT(*) AS count
+		FROM users u
+		WHERE u.created_at BETWEEN $2 AND $3
+		GROUP BY bucket`, interval, from, to).Bind(ctx, database.InstanceDB, &signups)
+	if err != nil {
+		logger.ErrorContext(ctx, "count signups", "error", err)
+		return nil, err
+	}
+
+	counts := make(map[int64]int64, len(signups))
+	for _, signup := range signups {
+		counts[signup.Start.Unix()] = signup.Count
+	}
+
+	return signupBuckets(from, to, interval, counts)
+}
+
+// ApproveUser is a function to activate a user created pending approval, the acting admin must have RoleAdmin
+var ApproveUser = func(ctx context.Context, adminID, userID int) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	if err := checkAdmin(ctx, database.InstanceDB, adminID); err != nil {
+		return 0, err
+	}
+
+	// Validate if exist user with id equal to userID
+	user, err := findUser(ctx, database.InstanceDB, userID, "id", "name", "email", "active")
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrUserNotFound
+		}
+
+		logger.ErrorContext(ctx, "find user", "error", err, "user_id", userID)
+		return 0, err
+	}
+
+	if user.Active {
+		return 0, ErrUserAlreadyActive
+	}
+
+	if err := checkMutableColumns("active"); err != nil {
+		return 0, err
+	}
+
+	// Activate user
+	user.Active = true
+	rowsAff, err := user.Update(ctx, database.InstanceDB, boil.Whitelist("active")) // only update active column
+	if err != nil {
+		logger.ErrorContext(ctx, "approve user", "error", err, "user_id", userID, "admin_id", adminID)
+		return 0, err
+	}
+
+	logger.InfoContext(ctx, "user approved", "user_id", userID, "admin_id", adminID)
+
+	runAfterUserHooks(ctx, AfterUpdate, user)
+
+	// Return affected rows with update
 	return rowsAff, nil
 }
+
+// FindSharedPasswordHashes is a function to group ids of users that have exactly the same stored password.
+// Salted hashes never repeat on their own, so a group means the hash was copied (e.g. a seeded default password).
+// Comparing the full stored string works for any hashing scheme. The acting admin must have RoleAdmin
+var FindSharedPasswordHashes = func(ctx context.Context, adminID int) ([][]int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := checkAdmin(ctx, database.InstanceDB, adminID); err != nil {
+		return nil, err
+	}
+
+	users, err := schema.Users(
+		qm.Select("id", "password"),
+		schema.UserWhere.DeletedAt.IsNull(),
+		qm.Where("password IN (SELECT password FROM users WHERE deleted_at IS NULL GROUP BY password HAVING COUNT(*) > 1)"),
+		qm.OrderBy("password, id"),
+	).All(ctx, database.InstanceDB)
+	if err != nil {
+		logger.ErrorContext(ctx, "find shared password hashes", "error", err)
+		return nil, err
+	}
+
+	// Users are ordered by password, so each group is a contiguous run
+	groups := make([][]int, 0)
+	for i, user := range users {
+		if i == 0 || user.Password != users[i-1].Password {
+			groups = append(groups, []int{})
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], user.ID)
+	}
+
+	return groups, nil
+}
+
+// AdminChangeEmail is a function to change the email of a user immediately, without user confirmation.
+// The acting admin must have RoleAdmin and the change is logged with the admin for audit
+var AdminChangeEmail = func(ctx context.Context, adminID, userID int, newEmail string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	newEmail = normalizeEmail(newEmail)
+	if err := validateEmail(newEmail); err != nil {
+		return err
+	}
+
+	if err := checkAdmin(ctx, database.InstanceDB, adminID); err != nil {
+		return err
+	}
+
+	// Validate if exist user with id equal to userID
+	user, err := findUser(ctx, database.InstanceDB, userID, "id", "email")
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUserNotFound
+		}
+
+		logger.ErrorContext(ctx, "find user", "error", err, "user_id", userID)
+		return err
+	}
+
+	if user.Email == newEmail {
+		return nil
+	}
+
+	if err := checkMutableColumns("email"); err != nil {
+		return err
+	}
+
+	// Validate if exist another registered user with same email
+	if err := checkEmailTakenByOther(ctx, database.InstanceDB, newEmail, userID); err != nil {
+		return err
+	}
+
+	// Update user email
+	oldEmail := user.Email
+	user.Email = newEmail
+	_, err = user.Update(ctx, database.InstanceDB, boil.Whitelist("email")) // only update email column
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ValidationErrors{emailTakenError}
+		}
+
+		logger.ErrorContext(ctx, "change user e-mail", "error", err, "user_id", userID, "admin_id", adminID)
+		return err
+	}
+
+	logger.InfoContext(ctx, "user e-mail changed by admin", "user_id", userID, "old_email", oldEmail, "email", newEmail, "admin_id", adminID)
+
+	runAfterUserHooks(ctx, AfterUpdate, user)
+
+	return nil
+}
+
+// exportBatchSize is the number of users fetched from database at a time by ExportUsersNDJSON
+const exportBatchSize = 500
+
+// exportedUser is a struct with the user columns that are safe to export
+type exportedUser struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ExportUsersNDJSON is a function to write all users into w as newline-delimited JSON, one user per line.
+// Users are read in batches with a keyset cursor on id, and w is flushed after each batch when it supports flushing
+var ExportUsersNDJSON = func(ctx context.Context, w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	lastID := 0
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		users, err := schema.Users(
+			qm.Select("id", "name", "email", "active", "created_at"),
+			schema.UserWhere.ID.GT(lastID),
+			schema.UserWhere.DeletedAt.IsNull(),
+			qm.OrderBy("id"),
+			qm.Limit(exportBatchSize),
+		).All(ctx, database.InstanceDB)
+		if err != nil {
+			logger.ErrorContext(ctx, "get users to export", "error", err)
+			return err
+		}
+
+		for _, user := range users {
+			err := encoder.Encode(exportedUser{user.ID, user.Name, user.Email, user.Active, user.CreatedAt})
+			if err != nil {
+				return err
+			}
+		}
+
+		// Flush written lines so consumers can start reading them
+		switch flusher := w.(type) {
+		case interface{ Flush() }:
+			flusher.Flush()
+		case interface{ Flush() error }:
+			if err := flusher.Flush(); err != nil {
+				return err
+			}
+		}
+
+		if len(users) < exportBatchSize {
+			return nil
+		}
+
+		lastID = users[len(users)-1].ID
+	}
+}
+
+// IsEmailOwnedBy is a function to check if email is the current email of the user with id equal to userID
+var IsEmailOwnedBy = func(ctx context.Context, userID int, email string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	owned, err := schema.Users(
+		schema.UserWhere.ID.EQ(userID),
+		emailEQ(normalizeEmail(email)),
+		schema.UserWhere.DeletedAt.IsNull(),
+	).Exists(ctx, database.InstanceDB)
+	if err != nil {
+		logger.ErrorContext(ctx, "check e-mail owner", "error", err, "user_id", userID)
+		return false, err
+	}
+
+	return owned, nil
+}
+
+// conditionalUserColumns is the allowlist of columns accepted by UpdateUserIf
+var conditionalUserColumns = map[string]bool{"name": true, "email": true, "active": true}
+
+// UpdateUserIf is a function to apply changes to a user only if its current values match expected, returning if
+// the update was applied. The precondition is part of the update WHERE clause, so there is no read-modify-write race
+var UpdateUserIf = func(ctx context.Context, userID int, expected map[string]interface{}, changes map[string]interface{}) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	if len(changes) == 0 {
+		return false, ErrNoChanges
+	}
+
+	// Build the precondition with allowlisted columns only
+	mods := []qm.QueryMod{schema.UserWhere.ID.EQ(userID), schema.UserWhere.DeletedAt.IsNull()}
+	for column, value := range expected {
+		if !conditionalUserColumns[column] {
+			return false, fmt.Errorf("%s cannot be used in a condition: %w", column, ErrColumnNotAllowed)
+		}
+		if column == "email" {
+			if email, ok := value.(string); ok {
+				value = normalizeEmail(email)
+			}
+		}
+		mods = append(mods, qm.Where(fmt.Sprintf("\"users\".\"%s\" = ?", column), value))
+	}
+
+	columns := make(schema.M, len(changes))
+	changedColumns := make([]string, 0, len(changes))
+	for column, value := range changes {
+		if !conditionalUserColumns[column] {
+			return false, fmt.Errorf("%s cannot be updated: %w", column, ErrColumnNotAllowed)
+		}
+		if column == "email" {
+			email, ok := value.(string)
+			if !ok {
+				return false, ValidationErrors{{Field: "email", Message: "User email must be a string!"}}
+			}
+			value = normalizeEmail(email)
+			if err := validateEmail(value.(string)); err != nil {
+				return false, err
+			}
+		}
+		columns[column] = value
+		changedColumns = append(changedColumns, column)
+	}
+
+	if err := checkMutableColumns(changedColumns...); err != nil {
+		return false, err
+	}
+
+	if email, ok := columns["email"].(string); ok {
+		// Validate if exist another registered user with same email
+		if err := checkEmailTakenByOther(ctx, database.InstanceDB, email, userID); err != nil {
+			return false, err
+		}
+	}
+
+	rowsAff, err := schema.Users(mods...).UpdateAll(ctx, database.InstanceDB, columns)
+	if err != nil {
+		// A concurrent update registered the same email first
+		if isUniqueViolation(err) {
+			return false, ValidationErrors{emailTakenError}
+		}
+
+		logger.ErrorContext(ctx, "conditional update user", "error", err, "user_id", userID)
+		return false, err
+	}
+
+	if rowsAff == 0 {
+		return false, nil
+	}
+
+	user, err := findUser(ctx, database.InstanceDB, userID, "id", "name", "email", "active")
+	if err == nil {
+		runAfterUserHooks(ctx, AfterUpdate, user)
+	}
+
+	return true, nil
+}
+
+// Limits of the number of users returned by ListRecentUsers
+const (
+	defaultRecentUsersLimit = 20
+	maxRecentUsersLimit     = 100
+)
+
+// ListRecentUsers is a function to return the last registered users, newest first
+var ListRecentUsers = func(ctx context.Context, limit int) ([]*schema.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if limit <= 0 {
+		limit = defaultRecentUsersLimit
+	} else if limit > maxRecentUsersLimit {
+		limit = maxRecentUsersLimit
+	}
+
+	users, err := schema.Users(
+		qm.Select("id", "name", "email", "active", "created_at"), // return only safe columns
+		schema.UserWhere.DeletedAt.IsNull(),
+		qm.OrderBy("created_at DESC, id DESC"),
+		qm.Limit(limit),
+	).All(ctx, database.InstanceDB)
+	if err != nil {
+		logger.ErrorContext(ctx, "list recent users", "error", err)
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// DomainCount is a struct that stores the number of users with emails of a domain
+type DomainCount struct {
+	Domain string `boil:"domain" json:"domain"`
+	Count  int64  `boil:"count" json:"count"`
+}
+
+// CountUsersByEmailDomain is a function to return the limit email domains with more users, ordered by count
+var CountUsersByEmailDomain = func(ctx context.Context, limit int) ([]DomainCount, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if limit <= 0 {
+		return nil, ErrInvalidLimit
+	}
+
+	domains := make([]DomainCount, 0)
+	err := queries.Raw(`
+		SELECT lower(split_part(email, '@', 2)) AS domain, COUNT(*) AS count
+		FROM users
+		WHERE deleted_at IS NULL
+		GROUP BY domain
+		ORDER BY count DESC, domain
+		LIMIT $1`, limit).Bind(ctx, database.InstanceDB, &domains)
+	if err != nil {
+		logger.ErrorContext(ctx, "count users by e-mail domain", "error", err)
+		return nil, err
+	}
+
+	return domains, nil
+}
+
+// DuplicateGroup is a struct that stores users whose emails are likely the same mailbox
+type DuplicateGroup struct {
+	NormalizedEmail string         `json:"normalized_email"`
+	Users           []*schema.User `json:"users"`
+}
+
+// canonicalMailbox is a function to reduce an email to the mailbox it delivers to. Gmail ignores dots and anything
+// after "+" in the local-part, and googlemail.com is the same as gmail.com
+var canonicalMailbox = func(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return email
+	}
+
+	local, domain := email[:at], email[at+1:]
+	if domain != "gmail.com" && domain != "googlemail.com" {
+		return email
+	}
+
+	if plus := strings.Index(local, "+"); plus >= 0 {
+		local = local[:plus]
+	}
+
+	return strings.Replace(local, ".", "", -1) + "@gmail.com"
+}
+
+// FindDuplicateCandidates is a function to group users whose emails deliver to the same mailbox, for admin review
+var FindDuplicateCandidates = func(ctx context.Context) ([]DuplicateGroup, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	users, err := schema.Users(qm.Select("id", "name", "email"), schema.UserWhere.DeletedAt.IsNull(), qm.OrderBy("id")).All(ctx, database.InstanceDB)
+	if err != nil {
+		logger.ErrorContext(ctx, "get users to compare e-mails", "error", err)
+		return nil, err
+	}
+
+	usersByMailbox := make(map[string][]*schema.User)
+	for _, user := range users {
+		mailbox := canonicalMailbox(user.Email)
+		usersByMailbox[mailbox] = append(usersByMailbox[mailbox], user)
+	}
+
+	groups := make([]DuplicateGroup, 0)
+	for mailbox, mailboxUsers := range usersByMailbox {
+		if len(mailboxUsers) > 1 {
+			groups = append(groups, DuplicateGroup{NormalizedEmail: mailbox, Users: mailboxUsers})
+		}
+	}
+
+	sort.Slice(groups, func(i, j int) bool { return groups[i].NormalizedEmail < groups[j].NormalizedEmail })
+
+	return groups, nil
+}
@@ -0,0 +1,1122 @@
+package models
+
+import (
+	"boilerplate/database"
+	"boilerplate/models/schema"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/volatiletech/sqlboiler/queries"
+	"github.com/volatiletech/sqlboiler/queries/qm"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// mockDatabase is a function to replace database.InstanceDB by a sqlmock database until the end of the test,
+// failing the test when an expected query was not executed
+func mockDatabase(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous := database.InstanceDB
+	database.InstanceDB = db
+	t.Cleanup(func() {
+		database.InstanceDB = previous
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+
+	return mock
+}
+
+// captureLogs is a function to write the models logs as JSON into the returned buffer until the end of the test
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	buffer := &bytes.Buffer{}
+	previous := logger
+	SetLogger(slog.New(slog.NewJSONHandler(buffer, nil)))
+	t.Cleanup(func() { logger = previous })
+
+	return buffer
+}
+
+// expectAdmin is a function to expect the query of checkAdmin, returning a user with role
+func expectAdmin(mock sqlmock.Sqlmock, adminID int, role string) {
+	mock.ExpectQuery(`SELECT "id", "active", "role" FROM "users"`).
+		WithArgs(adminID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "active", "role"}).AddRow(adminID, true, role))
+}
+
+func TestUserFilterModsCombineWithAnd(t *testing.T) {
+	createdAfter := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	query := schema.Users(userFilterMods(UserFilter{
+		NameContains:  "Ann",
+		EmailContains: "50%_off",
+		CreatedAfter:  &createdAfter,
+	})...)
+
+	sql, args := queries.BuildQuery(query.Query)
+
+	for _, clause := range []string{"name ILIKE $1", "email ILIKE $2", "\"users\".\"created_at\" > $3"} {
+		if !strings.Contains(sql, clause) {
+			t.Errorf("expected query to contain %q, got: %s", clause, sql)
+		}
+	}
+	if strings.Count(sql, " AND ") != 3 {
+		t.Errorf("expected all conditions combined with AND, got: %s", sql)
+	}
+
+	expectedArgs := []interface{}{"%Ann%", `%50\%\_off%`, createdAfter}
+	if !reflect.DeepEqual(args, expectedArgs) {
+		t.Errorf("expected args %v, got %v", expectedArgs, args)
+	}
+}
+
+func TestUserFilterModsEmptyFilter(t *testing.T) {
+	query := schema.Users(userFilterMods(UserFilter{})...)
+
+	sql, args := queries.BuildQuery(query.Query)
+
+	if strings.Contains(sql, "ILIKE") || len(args) != 0 {
+		t.Errorf("expected no search conditions, got: %s %v", sql, args)
+	}
+}
+
+func TestAccountLockoutExpires(t *testing.T) {
+	defer func(attempts int, duration time.Duration) {
+		MaxFailedLoginAttempts, LockoutDuration = attempts, duration
+	}(MaxFailedLoginAttempts, LockoutDuration)
+	MaxFailedLoginAttempts, LockoutDuration = 2, time.Minute
+
+	now := time.Now()
+	user := &schema.User{}
+
+	if user.LockedUntil = lockoutFor(1, now); user.LockedUntil.Valid {
+		t.Fatalf("expected no lock before %d failed attempts", MaxFailedLoginAttempts)
+	}
+
+	user.LockedUntil = lockoutFor(2, now)
+	if !isAccountLocked(user, now) {
+		t.Fatalf("expected lock after %d failed attempts", MaxFailedLoginAttempts)
+	}
+	if !isAccountLocked(user, now.Add(LockoutDuration-time.Second)) {
+		t.Errorf("expected lock to last %s", LockoutDuration)
+	}
+	if isAccountLocked(user, now.Add(LockoutDuration)) {
+		t.Errorf("expected lock to expire after %s", LockoutDuration)
+	}
+}
+
+func TestAdminChangeEmail(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("changes email and logs the admin", func(t *testing.T) {
+		mock := mockDatabase(t)
+		logs := captureLogs(t)
+
+		expectAdmin(mock, 1, RoleAdmin)
+		mock.ExpectQuery(`SELECT "id", "email" FROM "users"`).
+			WithArgs(2).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(2, "old@example.com"))
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM "users"`).
+			WithArgs("new@example.com", 2).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectExec(`UPDATE "users" SET "email"=\$1 WHERE "id"=\$2`).
+			WithArgs("new@example.com", 2).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		if err := AdminChangeEmail(ctx, 1, 2, " New@Example.com "); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		for _, expected := range []string{`"msg":"user e-mail changed by admin"`, `"user_id":2`, `"old_email":"old@example.com"`, `"email":"new@example.com"`, `"admin_id":1`} {
+			if !strings.Contains(logs.String(), expected) {
+				t.Errorf("expected audit log to contain %s, got: %s", expected, logs.String())
+			}
+		}
+	})
+
+	t.Run("rejects email of another user", func(t *testing.T) {
+		mock := mockDatabase(t)
+
+		expectAdmin(mock, 1, RoleAdmin)
+		mock.ExpectQuery(`SELECT "id", "email" FROM "users"`).
+			WithArgs(2).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(2, "old@example.com"))
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM "users"`).
+			WithArgs("taken@example.com", 2).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+		var validationErrors ValidationErrors
+		if err := AdminChangeEmail(ctx, 1, 2, "taken@example.com"); !errors.As(err, &validationErrors) || !errors.Is(err, ErrEmailTaken) {
+			t.Fatalf("expected ValidationErrors with ErrEmailTaken, got %#v", err)
+		}
+	})
+
+	t.Run("rejects email registered concurrently", func(t *testing.T) {
+		mock := mockDatabase(t)
+
+		expectAdmin(mock, 1, RoleAdmin)
+		mock.ExpectQuery(`SELECT "id", "email" FROM "users"`).
+			WithArgs(2).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(2, "old@example.com"))
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM "users"`).
+			WithArgs("taken@example.com", 2).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectExec(`UPDATE "users" SET "email"=\$1 WHERE "id"=\$2`).
+			WithArgs("taken@example.com", 2).
+			WillReturnError(&pq.Error{Code: "23505"})
+
+		var validationErrors ValidationErrors
+		if err := AdminChangeEmail(ctx, 1, 2, "taken@example.com"); !errors.As(err, &validationErrors) || !errors.Is(err, ErrEmailTaken) {
+			t.Fatalf("expected ValidationErrors with ErrEmailTaken, got %#v", err)
+		}
+	})
+
+	t.Run("rejects users that are not admins", func(t *testing.T) {
+		mock := mockDatabase(t)
+
+		expectAdmin(mock, 1, RoleUser)
+
+		if err := AdminChangeEmail(ctx, 1, 2, "new@example.com"); !errors.Is(err, ErrNotAdmin) {
+			t.Fatalf("expected ErrNotAdmin, got %v", err)
+		}
+	})
+}
+
+func TestApproveUserAllowsLogin(t *testing.T) {
+	ctx := context.Background()
+	mock := mockDatabase(t)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectLogin := func(active bool) {
+		mock.ExpectQuery(`SELECT "id", "password", "active", "failed_login_attempts", "locked_until" FROM "users"`).
+			WithArgs("pending@example.com").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "password", "active", "failed_login_attempts", "locked_until"}).
+				AddRow(2, string(hash), active, 0, nil))
+	}
+
+	// Pending user cannot login
+	expectLogin(false)
+	if _, err := Authenticate(ctx, "pending@example.com", "secret123"); !errors.Is(err, ErrPendingApproval) {
+		t.Fatalf("expected ErrPendingApproval before approval, got %v", err)
+	}
+
+	// Only admins approve users
+	expectAdmin(mock, 3, RoleUser)
+	if _, err := ApproveUser(ctx, 3, 2); !errors.Is(err, ErrNotAdmin) {
+		t.Fatalf("expected ErrNotAdmin, got %v", err)
+	}
+
+	expectAdmin(mock, 1, RoleAdmin)
+	mock.ExpectQuery(`SELECT "id", "name", "email", "active" FROM "users"`).
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email", "active"}).AddRow(2, "Pending", "pending@example.com", false))
+	mock.ExpectExec(`UPDATE "users" SET "active"=\$1 WHERE "id"=\$2`).
+		WithArgs(true, 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if _, err := ApproveUser(ctx, 1, 2); err != nil {
+		t.Fatalf("expected approval, got %v", err)
+	}
+
+	// Approved user can login
+	expectLogin(true)
+	if valid, err := Authenticate(ctx, "pending@example.com", "secret123"); !valid || err != nil {
+		t.Fatalf("expected login after approval, got %v %v", valid, err)
+	}
+}
+
+func TestEmailEQUsesLowerEmail(t *testing.T) {
+	for _, preserve := range []string{"false", "true"} {
+		t.Run("email_preserve_local_part="+preserve, func(t *testing.T) {
+			t.Setenv("email_preserve_local_part", preserve)
+
+			sql, _ := queries.BuildQuery(schema.Users(emailEQ("John@example.com")).Query)
+
+			if !strings.Contains(sql, "lower(email) = lower($1)") {
+				t.Errorf("expected query to compare lower(email), got: %s", sql)
+			}
+		})
+	}
+}
+
+func TestEmailEQPreserveLocalPart(t *testing.T) {
+	t.Setenv("email_preserve_local_part", "true")
+
+	// The SQL expression applied to the stored email is normalizeEmail, so a row matches when its normalized
+	// email equals the second argument
+	cases := []struct {
+		name    string
+		stored  string
+		lookup  string
+		matches bool
+	}{
+		{"same email", "John@example.com", "John@example.com", true},
+		{"different domain case", "John@example.com", "John@EXAMPLE.com", true},
+		{"different local part case", "John@example.com", "john@example.com", false},
+		{"@ in local part", "a@B@example.com", "a@B@Example.COM", true},
+		{"@ in local part with different case", "a@B@example.com", "a@b@example.com", false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			sql, args := queries.BuildQuery(schema.Users(emailEQ(c.lookup)).Query)
+
+			if !strings.Contains(sql, "left(email, length(email) - position('@' in reverse(email)))") {
+				t.Fatalf("expected query to split the stored email at the last @, got: %s", sql)
+			}
+			if len(args) != 2 || args[0] != c.lookup {
+				t.Fatalf("expected lookup email and its normalized form as arguments, got %v", args)
+			}
+			if matches := args[1] == normalizeEmail(c.stored); matches != c.matches {
+				t.Errorf("expected match %v for %q, got argument %q", c.matches, c.stored, args[1])
+			}
+		})
+	}
+}
+
+func TestUpdateUserIf(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("applied when expected values match", func(t *testing.T) {
+		mock := mockDatabase(t)
+
+		mock.ExpectExec(`UPDATE "users" SET "name" = \$1 WHERE .*"users"."name" = \$3`).
+			WithArgs("New", 1, "Old").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`SELECT "id", "name", "email", "active" FROM "users"`).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email", "active"}).AddRow(1, "New", "user@example.com", true))
+
+		applied, err := UpdateUserIf(ctx, 1, map[string]interface{}{"name": "Old"}, map[string]interface{}{"name": "New"})
+		if err != nil || !applied {
+			t.Fatalf("expected update applied, got %v %v", applied, err)
+		}
+	})
+
+	t.Run("not applied when expected values changed", func(t *testing.T) {
+		mock := mockDatabase(t)
+
+		mock.ExpectExec(`UPDATE "users" SET "name" = \$1 WHERE .*"users"."name" = \$3`).
+			WithArgs("New", 1, "Old").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		applied, err := UpdateUserIf(ctx, 1, map[string]interface{}{"name": "Old"}, map[string]interface{}{"name": "New"})
+		if err != nil || applied {
+			t.Fatalf("expected update not applied, got %v %v", applied, err)
+		}
+	})
+
+	t.Run("rejects invalid email", func(t *testing.T) {
+		mockDatabase(t)
+
+		_, err := UpdateUserIf(ctx, 1, nil, map[string]interface{}{"email": "not an email"})
+		if !errors.Is(err, ErrValidation) {
+			t.Fatalf("expected ErrValidation, got %v", err)
+		}
+	})
+
+	t.Run("rejects email of another user", func(t *testing.T) {
+		mock := mockDatabase(t)
+
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM "users"`).
+			WithArgs("taken@example.com", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+		var validationErrors ValidationErrors
+		_, err := UpdateUserIf(ctx, 1, nil, map[string]interface{}{"email": "Taken@example.com"})
+		if !errors.As(err, &validationErrors) || !errors.Is(err, ErrEmailTaken) {
+			t.Fatalf("expected ValidationErrors with ErrEmailTaken, got %#v", err)
+		}
+	})
+
+	t.Run("rejects email registered concurrently", func(t *testing.T) {
+		mock := mockDatabase(t)
+
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM "users"`).
+			WithArgs("taken@example.com", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectExec(`UPDATE "users" SET "email" = \$1`).
+			WillReturnError(&pq.Error{Code: "23505"})
+
+		var validationErrors ValidationErrors
+		_, err := UpdateUserIf(ctx, 1, nil, map[string]interface{}{"email": "Taken@example.com"})
+		if !errors.As(err, &validationErrors) || !errors.Is(err, ErrEmailTaken) {
+			t.Fatalf("expected ValidationErrors with ErrEmailTaken, got %#v", err)
+		}
+	})
+}
+
+func TestDeleteUserByIDErrors(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("missing user", func(t *testing.T) {
+		mock := mockDatabase(t)
+		mock.ExpectQuery(`SELECT .* FROM "users"`).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		if _, err := DeleteUserByID(ctx, 1); !errors.Is(err, ErrUserNotFound) {
+			t.Fatalf("expected ErrUserNotFound, got %v", err)
+		}
+	})
+
+	t.Run("database failure", func(t *testing.T) {
+		mock := mockDatabase(t)
+		failure := errors.New("connection refused")
+		mock.ExpectQuery(`SELECT .* FROM "users"`).WithArgs(1).WillReturnError(failure)
+
+		if _, err := DeleteUserByID(ctx, 1); !errors.Is(err, failure) || errors.Is(err, ErrUserNotFound) {
+			t.Fatalf("expected the database error, got %v", err)
+		}
+	})
+}
+
+func TestNewUserEmailTakenIsValidationError(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("bcrypt_cost", "4")
+	newUser := func() *schema.User {
+		return &schema.User{Name: "User", Email: "taken@example.com", Password: "secret123"}
+	}
+
+	expectTakenError := func(t *testing.T, err error) {
+		t.Helper()
+
+		var validationErrors ValidationErrors
+		if !errors.As(err, &validationErrors) || !errors.Is(err, ErrEmailTaken) {
+			t.Fatalf("expected ValidationErrors with ErrEmailTaken, got %#v", err)
+		}
+	}
+
+	t.Run("found by validation", func(t *testing.T) {
+		mock := mockDatabase(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM "users"`).
+			WithArgs("taken@example.com").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectRollback()
+
+		_, err := NewUser(ctx, newUser())
+		expectTakenError(t, err)
+	})
+
+	t.Run("found by unique index", func(t *testing.T) {
+		mock := mockDatabase(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM "users"`).
+			WithArgs("taken@example.com").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(`INSERT INTO "users"`).WillReturnError(&pq.Error{Code: "23505"})
+		mock.ExpectRollback()
+
+		_, err := NewUser(ctx, newUser())
+		expectTakenError(t, err)
+	})
+}
+
+func TestTruncateToInterval(t *testing.T) {
+	cases := []struct {
+		interval string
+		time     time.Time
+		expected time.Time
+	}{
+		{"day", time.Date(2020, 3, 1, 23, 59, 59, 0, time.UTC), time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"day", time.Date(2020, 3, 2, 0, 0, 0, 0, time.UTC), time.Date(2020, 3, 2, 0, 0, 0, 0, time.UTC)},
+		{"day", time.Date(2020, 3, 1, 22, 0, 0, 0, time.FixedZone("UTC-3", -3*3600)), time.Date(2020, 3, 2, 0, 0, 0, 0, time.UTC)},
+		{"week", time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC), time.Date(2020, 2, 24, 0, 0, 0, 0, time.UTC)}, // sunday
+		{"week", time.Date(2020, 3, 2, 12, 0, 0, 0, time.UTC), time.Date(2020, 3, 2, 0, 0, 0, 0, time.UTC)},  // monday
+		{"month", time.Date(2020, 2, 29, 23, 59, 59, 0, time.UTC), time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"month", time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, c := range cases {
+		if truncated := truncateToInterval(c.time, c.interval); !truncated.Equal(c.expected) {
+			t.Errorf("truncate %s to %s: expected %s, got %s", c.time, c.interval, c.expected, truncated)
+		}
+	}
+}
+
+func TestSignupBucketsLimit(t *testing.T) {
+	from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if _, err := signupBuckets(from, from.AddDate(0, 0, maxSignupBuckets-1), "day", nil); err != nil {
+		t.Errorf("expected %d days to be accepted, got %v", maxSignupBuckets, err)
+	}
+	if _, err := signupBuckets(from, from.AddDate(0, 0, maxSignupBuckets), "day", nil); err == nil {
+		t.Errorf("expected more than %d days to be rejected", maxSignupBuckets)
+	}
+	if _, err := SignupsOverTime(context.Background(), time.Time{}, from, "day"); err == nil {
+		t.Error("expected period since year 1 by day to be rejected")
+	}
+}
+
+func TestSignupsOverTimeZeroFill(t *testing.T) {
+	mock := mockDatabase(t)
+	from := time.Date(2020, 3, 1, 10, 0, 0, 0, time.UTC)
+	to := time.Date(2020, 3, 4, 10, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery(`SELECT date_trunc\(\$1, u.created_at AT TIME ZONE 'UTC'\) AS bucket`).
+		WithArgs("day", from, to).
+		WillReturnRows(sqlmock.NewRows([]string{"bucket", "count"}).
+			AddRow(time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC), 2).
+			AddRow(time.Date(2020, 3, 3, 0, 0, 0, 0, time.UTC), 5))
+
+	buckets, err := SignupsOverTime(context.Background(), from, to, "day")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []int64{2, 0, 5, 0}
+	if len(buckets) != len(expected) {
+		t.Fatalf("expected %d buckets, got %v", len(expected), buckets)
+	}
+	for i, bucket := range buckets {
+		if start := from.AddDate(0, 0, i).Truncate(24 * time.Hour); !bucket.Start.Equal(start) || bucket.Count != expected[i] {
+			t.Errorf("bucket %d: expected %s with %d, got %s with %d", i, start, expected[i], bucket.Start, bucket.Count)
+		}
+	}
+}
+
+func TestNormalizeEmail(t *testing.T) {
+	cases := []struct {
+		preserve string
+		email    string
+		expected string
+	}{
+		{"false", "  John.Doe@Example.COM ", "john.doe@example.com"},
+		{"false", "user@example.com", "user@example.com"},
+		{"false", "Not An Email", "not an email"},
+		{"true", "  John.Doe@Example.COM ", "John.Doe@example.com"},
+		{"true", "a@b@Example.COM", "a@b@example.com"},
+		{"true", "No-At-Sign", "No-At-Sign"},
+	}
+
+	for _, c := range cases {
+		t.Run("email_preserve_local_part="+c.preserve+"/"+c.email, func(t *testing.T) {
+			t.Setenv("email_preserve_local_part", c.preserve)
+
+			if normalized := normalizeEmail(c.email); normalized != c.expected {
+				t.Errorf("expected %q, got %q", c.expected, normalized)
+			}
+		})
+	}
+}
+
+func TestValidatePassword(t *testing.T) {
+	cases := []struct {
+		name     string
+		password string
+		expected string
+	}{
+		{"empty", "", "User password cannot be empty!"},
+		{"too short", "12345", "User password must be at least 6 characters!"},
+		{"compliant", "123456", ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := ValidatePassword(c.password)
+			if c.expected == "" {
+				if err != nil {
+					t.Fatalf("expected password to be compliant, got %v", err)
+				}
+				return
+			}
+
+			var validationErrors ValidationErrors
+			if !errors.As(err, &validationErrors) {
+				t.Fatalf("expected ValidationErrors, got %v", err)
+			}
+			if len(validationErrors) != 1 || validationErrors[0].Field != "password" || validationErrors[0].Message != c.expected {
+				t.Errorf("expected password error %q, got %+v", c.expected, validationErrors)
+			}
+		})
+	}
+}
+
+func TestCanonicalMailbox(t *testing.T) {
+	cases := []struct {
+		email    string
+		expected string
+	}{
+		{"john.doe@gmail.com", "johndoe@gmail.com"},
+		{"John.Doe+news@Gmail.com", "johndoe@gmail.com"},
+		{"j.o.h.n.doe+a+b@googlemail.com", "johndoe@gmail.com"},
+		{"  johndoe@GOOGLEMAIL.COM ", "johndoe@gmail.com"},
+		{"john.doe+news@example.com", "john.doe+news@example.com"},
+		{"no-at-sign", "no-at-sign"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.email, func(t *testing.T) {
+			if mailbox := canonicalMailbox(c.email); mailbox != c.expected {
+				t.Errorf("expected %q, got %q", c.expected, mailbox)
+			}
+		})
+	}
+}
+
+func TestFindDuplicateCandidates(t *testing.T) {
+	mock := mockDatabase(t)
+	mock.ExpectQuery(`SELECT "id", "name", "email" FROM "users"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email"}).
+			AddRow(1, "John", "john.doe@gmail.com").
+			AddRow(2, "John", "johndoe+shop@googlemail.com").
+			AddRow(3, "Jane", "jane@example.com").
+			AddRow(4, "Jane", "jane+shop@example.com"))
+
+	groups, err := FindDuplicateCandidates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(groups) != 1 || groups[0].NormalizedEmail != "johndoe@gmail.com" {
+		t.Fatalf("expected only the gmail users to be grouped, got %+v", groups)
+	}
+	if len(groups[0].Users) != 2 || groups[0].Users[0].ID != 1 || groups[0].Users[1].ID != 2 {
+		t.Errorf("expected users 1 and 2 in the group, got %+v", groups[0].Users)
+	}
+}
+
+func TestHashPassword(t *testing.T) {
+	t.Setenv("bcrypt_cost", "4")
+
+	hash, err := hashPassword("secret123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !isPasswordHashed(hash) {
+		t.Errorf("expected %q to be detected as hashed", hash)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte("secret123")) != nil {
+		t.Error("expected hash to match the password")
+	}
+	if isPasswordHashed("secret123") {
+		t.Error("expected legacy plaintext password not to be detected as hashed")
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("bcrypt_cost", "4")
+	hash, err := hashPassword("secret123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectUser := func(mock sqlmock.Sqlmock, password string) {
+		mock.ExpectQuery(`SELECT "id", "password", "active", "failed_login_attempts", "locked_until" FROM "users"`).
+			WithArgs("user@example.com").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "password", "active", "failed_login_attempts", "locked_until"}).
+				AddRow(1, password, true, 0, nil))
+	}
+
+	t.Run("correct password", func(t *testing.T) {
+		mock := mockDatabase(t)
+		expectUser(mock, hash)
+
+		if ok, err := Authenticate(ctx, "user@example.com", "secret123"); !ok || err != nil {
+			t.Errorf("expected authentication to succeed, got %v %v", ok, err)
+		}
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		mock := mockDatabase(t)
+		expectUser(mock, hash)
+		mock.ExpectQuery(`UPDATE users SET failed_login_attempts = failed_login_attempts \+ 1`).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"failed_login_attempts"}).AddRow(1))
+
+		if ok, err := Authenticate(ctx, "user@example.com", "wrong123"); ok || !errors.Is(err, ErrPasswordMismatch) {
+			t.Errorf("expected ErrPasswordMismatch, got %v %v", ok, err)
+		}
+	})
+
+	t.Run("legacy plaintext password is rehashed", func(t *testing.T) {
+		mock := mockDatabase(t)
+		expectUser(mock, "secret123")
+		mock.ExpectExec(`UPDATE "users" SET "password"=\$1 WHERE "id"=\$2`).
+			WithArgs(sqlmock.AnyArg(), 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		if ok, err := Authenticate(ctx, "user@example.com", "secret123"); !ok || err != nil {
+			t.Errorf("expected authentication to succeed, got %v %v", ok, err)
+		}
+	})
+}
+
+func TestNewUserConcurrentReturnsOwnUser(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("bcrypt_cost", "4")
+	emails := map[int]string{1: "first@example.com", 2: "second@example.com"}
+
+	// Both transactions run at the same time, so the expectations are matched by their arguments
+	mock := mockDatabase(t)
+	mock.MatchExpectationsInOrder(false)
+	for id, email := range emails {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM "users"`).
+			WithArgs(email).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(`INSERT INTO "users"`).
+			WithArgs("User", email, sqlmock.AnyArg(), nil, sqlmock.AnyArg(), true, nil, nil, nil, nil).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "failed_login_attempts", "role"}).AddRow(id, 0, RoleUser))
+		mock.ExpectQuery(`select "id","name","email" from "users" where "id"=\$1`).
+			WithArgs(id).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email"}).AddRow(id, "User", email))
+		mock.ExpectCommit()
+	}
+
+	var wg sync.WaitGroup
+	for _, email := range emails {
+		wg.Add(1)
+		go func(email string) {
+			defer wg.Done()
+
+			created, err := NewUser(ctx, &schema.User{Name: "User", Email: email, Password: "secret123"})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if created.Email != email || emails[created.ID] != email {
+				t.Errorf("expected created user with e-mail %s, got %+v", email, created)
+			}
+		}(email)
+	}
+	wg.Wait()
+}
+
+func TestDeleteUsersInBatches(t *testing.T) {
+	const total, batchSize = 1000, 100
+
+	mock := mockDatabase(t)
+	for first := 1; first <= total+1; first += batchSize {
+		rows := sqlmock.NewRows([]string{"id"})
+		ids := make([]driver.Value, 0, batchSize)
+		for id := first; id < first+batchSize && id <= total; id++ {
+			rows.AddRow(id)
+			ids = append(ids, id)
+		}
+
+		// Each batch must start right after the last deleted id and keep the filter outside the cursor
+		mock.ExpectQuery(`SELECT "id" FROM "users" WHERE "users"\."id" > \$1 AND \("users"\."active" = \$2 OR "users"\."role" = \$3\) ORDER BY id LIMIT 100`).
+			WithArgs(first-1, false, RoleUser).
+			WillReturnRows(rows)
+		if len(ids) > 0 {
+			mock.ExpectExec(`DELETE FROM "users" WHERE \("id"=\$1\) OR`).
+				WithArgs(ids...).
+				WillReturnResult(sqlmock.NewResult(0, int64(len(ids))))
+		}
+	}
+
+	deleted, err := DeleteUsersInBatches(context.Background(), batchSize,
+		schema.UserWhere.Active.EQ(false), qm.Or2(schema.UserWhere.Role.EQ(RoleUser)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != total {
+		t.Errorf("expected %d users deleted, got %d", total, deleted)
+	}
+}
+
+func TestImmutableUserColumns(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("immutable_user_columns", "role, email")
+
+	expectStoredUser := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(`SELECT \* FROM "users"`).
+			WithArgs(2).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email"}).AddRow(2, "User", "user@example.com"))
+	}
+
+	t.Run("immutable column is rejected", func(t *testing.T) {
+		mock := mockDatabase(t)
+		expectStoredUser(mock)
+
+		_, err := UpdateUser(ctx, &schema.User{ID: 2, Name: "User", Email: "new@example.com"})
+		if !errors.Is(err, ErrImmutableField) {
+			t.Fatalf("expected ErrImmutableField, got %v", err)
+		}
+	})
+
+	t.Run("other columns still change", func(t *testing.T) {
+		mock := mockDatabase(t)
+		expectStoredUser(mock)
+		mock.ExpectExec(`UPDATE "users" SET "name"=\$1,"email"=\$2 WHERE "id"=\$3`).
+			WithArgs("New name", "user@example.com", 2).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		if _, err := UpdateUser(ctx, &schema.User{ID: 2, Name: "New name", Email: "user@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("configured list", func(t *testing.T) {
+		if err := checkMutableColumns("role"); !errors.Is(err, ErrImmutableField) {
+			t.Errorf("expected role to be immutable, got %v", err)
+		}
+		if err := checkMutableColumns("name", "password"); err != nil {
+			t.Errorf("expected name and password to be mutable, got %v", err)
+		}
+	})
+}
+
+func TestExportUsersNDJSON(t *testing.T) {
+	const total = exportBatchSize
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// A full batch must be followed by a query after its last id, which ends the export when empty
+	mock := mockDatabase(t)
+	rows := sqlmock.NewRows([]string{"id", "name", "email", "active", "created_at"})
+	for id := 1; id <= total; id++ {
+		rows.AddRow(id, "User", "user@example.com", id%2 == 0, createdAt)
+	}
+	mock.ExpectQuery(`SELECT "id", "name", "email", "active", "created_at" FROM "users" WHERE \("users"\."id" > \$1\)`).
+		WithArgs(0).
+		WillReturnRows(rows)
+	mock.ExpectQuery(`SELECT "id", "name", "email", "active", "created_at" FROM "users" WHERE \("users"\."id" > \$1\)`).
+		WithArgs(total).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email", "active", "created_at"}))
+
+	output := &bytes.Buffer{}
+	if err := ExportUsersNDJSON(context.Background(), output); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n")
+	if len(lines) != total {
+		t.Fatalf("expected %d lines, got %d", total, len(lines))
+	}
+	for i, line := range lines {
+		var user exportedUser
+		if err := json.Unmarshal([]byte(line), &user); err != nil {
+			t.Fatalf("line %d is not a user: %v", i+1, err)
+		}
+		if user.ID != i+1 || user.Email != "user@example.com" || user.Active != (user.ID%2 == 0) || !user.CreatedAt.Equal(createdAt) {
+			t.Errorf("unexpected user in line %d: %+v", i+1, user)
+		}
+	}
+}
+
+func TestListRecentUsers(t *testing.T) {
+	ctx := context.Background()
+	older := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+
+	t.Run("newest first", func(t *testing.T) {
+		// Ordering is done by database, rows are returned as the ORDER BY would sort them
+		mock := mockDatabase(t)
+		mock.ExpectQuery(`SELECT "id", "name", "email", "active", "created_at" FROM "users" WHERE \("users"\."deleted_at" is null\) ORDER BY created_at DESC, id DESC LIMIT 20`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email", "active", "created_at"}).
+				AddRow(3, "Third", "third@example.com", true, newer).
+				AddRow(2, "Second", "second@example.com", true, newer).
+				AddRow(1, "First", "first@example.com", true, older))
+
+		users, err := ListRecentUsers(ctx, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ids := make([]int, 0)
+		for _, user := range users {
+			ids = append(ids, user.ID)
+		}
+		if !reflect.DeepEqual(ids, []int{3, 2, 1}) {
+			t.Errorf("expected users 3, 2, 1, got %v", ids)
+		}
+	})
+
+	cases := []struct {
+		limit    int
+		expected string
+	}{
+		{-1, "LIMIT 20"},
+		{0, "LIMIT 20"},
+		{5, "LIMIT 5"},
+		{maxRecentUsersLimit, "LIMIT 100"},
+		{maxRecentUsersLimit + 1, "LIMIT 100"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.expected+" for "+strconv.Itoa(c.limit), func(t *testing.T) {
+			mock := mockDatabase(t)
+			mock.ExpectQuery(`ORDER BY created_at DESC, id DESC ` + c.expected + `;`).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+			if _, err := ListRecentUsers(ctx, c.limit); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestFindSharedPasswordHashes(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("groups copied hashes", func(t *testing.T) {
+		mock := mockDatabase(t)
+		expectAdmin(mock, 1, RoleAdmin)
+		// Database returns only the users whose password is shared, user 4 has a unique hash
+		mock.ExpectQuery(`SELECT "id", "password" FROM "users" WHERE .*GROUP BY password HAVING COUNT\(\*\) > 1\).* ORDER BY password, id`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "password"}).
+				AddRow(2, "$2a$10$copied").
+				AddRow(3, "$2a$10$copied"))
+
+		groups, err := FindSharedPasswordHashes(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(groups, [][]int{{2, 3}}) {
+			t.Errorf("expected one group [2 3], got %v", groups)
+		}
+	})
+
+	t.Run("not admin", func(t *testing.T) {
+		mock := mockDatabase(t)
+		expectAdmin(mock, 1, RoleUser)
+
+		if _, err := FindSharedPasswordHashes(ctx, 1); !errors.Is(err, ErrNotAdmin) {
+			t.Errorf("expected ErrNotAdmin, got %v", err)
+		}
+	})
+}
+
+func TestAuthenticateLockout(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("bcrypt_cost", "4")
+	defer func(attempts int, duration time.Duration) {
+		MaxFailedLoginAttempts, LockoutDuration = attempts, duration
+	}(MaxFailedLoginAttempts, LockoutDuration)
+	MaxFailedLoginAttempts, LockoutDuration = 2, time.Minute
+
+	hash, err := hashPassword("secret123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectUser := func(mock sqlmock.Sqlmock, attempts int, lockedUntil interface{}) {
+		mock.ExpectQuery(`SELECT "id", "password", "active", "failed_login_attempts", "locked_until" FROM "users"`).
+			WithArgs("user@example.com").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "password", "active", "failed_login_attempts", "locked_until"}).
+				AddRow(1, hash, true, attempts, lockedUntil))
+	}
+
+	t.Run("last allowed mismatch locks the user", func(t *testing.T) {
+		mock := mockDatabase(t)
+		expectUser(mock, 1, nil)
+		mock.ExpectQuery(`UPDATE users SET failed_login_attempts = failed_login_attempts \+ 1 WHERE id = \$1 RETURNING failed_login_attempts`).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"failed_login_attempts"}).AddRow(2))
+		mock.ExpectExec(`UPDATE "users" SET "failed_login_attempts" = \$1, "locked_until" = \$2 WHERE \("users"\."id" = \$3\)`).
+			WithArgs(0, sqlmock.AnyArg(), 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		if _, err := Authenticate(ctx, "user@example.com", "wrong123"); !errors.Is(err, ErrPasswordMismatch) {
+			t.Errorf("expected ErrPasswordMismatch, got %v", err)
+		}
+	})
+
+	t.Run("locked user is rejected without writes", func(t *testing.T) {
+		mock := mockDatabase(t)
+		expectUser(mock, 0, time.Now().Add(LockoutDuration))
+
+		if _, err := Authenticate(ctx, "user@example.com", "secret123"); !errors.Is(err, ErrAccountLocked) {
+			t.Errorf("expected ErrAccountLocked, got %v", err)
+		}
+	})
+
+	t.Run("expired lock allows login and clears it", func(t *testing.T) {
+		mock := mockDatabase(t)
+		expectUser(mock, 0, time.Now().Add(-time.Second))
+		mock.ExpectExec(`UPDATE "users" SET "failed_login_attempts"=\$1,"locked_until"=\$2 WHERE "id"=\$3`).
+			WithArgs(0, nil, 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		if ok, err := Authenticate(ctx, "user@example.com", "secret123"); !ok || err != nil {
+			t.Errorf("expected authentication to succeed, got %v %v", ok, err)
+		}
+	})
+}
+
+func TestIsEmailOwnedBy(t *testing.T) {
+	cases := []struct {
+		name     string
+		email    string
+		count    int
+		expected bool
+	}{
+		{"own e-mail", "user@example.com", 1, true},
+		{"own e-mail with other case and spaces", "  User@Example.COM ", 1, true},
+		{"e-mail of another user", "other@example.com", 0, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mock := mockDatabase(t)
+			mock.ExpectQuery(`SELECT COUNT\(\*\) FROM "users" WHERE \("users"\."id" = \$1\) AND \(lower\(email\) = lower\(\$2\)\)`).
+				WithArgs(1, strings.ToLower(strings.TrimSpace(c.email))).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(c.count))
+
+			owned, err := IsEmailOwnedBy(context.Background(), 1, c.email)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if owned != c.expected {
+				t.Errorf("expected %v, got %v", c.expected, owned)
+			}
+		})
+	}
+}
+
+func TestCountUsersByEmailDomain(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("top domains", func(t *testing.T) {
+		// Aggregation is done by database, rows are returned as the query groups and sorts them
+		mock := mockDatabase(t)
+		mock.ExpectQuery(`SELECT lower\(split_part\(email, '@', 2\)\) AS domain, COUNT\(\*\) AS count\s+FROM users\s+WHERE deleted_at IS NULL\s+GROUP BY domain\s+ORDER BY count DESC, domain\s+LIMIT \$1`).
+			WithArgs(3).
+			WillReturnRows(sqlmock.NewRows([]string{"domain", "count"}).
+				AddRow("gmail.com", 5).
+				AddRow("example.com", 2).
+				AddRow("outlook.com", 2))
+
+		domains, err := CountUsersByEmailDomain(ctx, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []DomainCount{{"gmail.com", 5}, {"example.com", 2}, {"outlook.com", 2}}
+		if !reflect.DeepEqual(domains, expected) {
+			t.Errorf("expected %v, got %v", expected, domains)
+		}
+	})
+
+	t.Run("invalid limit", func(t *testing.T) {
+		if _, err := CountUsersByEmailDomain(ctx, 0); !errors.Is(err, ErrInvalidLimit) {
+			t.Errorf("expected ErrInvalidLimit, got %v", err)
+		}
+	})
+}
+
+// captureArg is a sqlmock argument matching any value and storing it
+type captureArg struct {
+	value *driver.Value
+}
+
+// Match implements sqlmock.Argument
+func (arg captureArg) Match(value driver.Value) bool {
+	*arg.value = value
+	return true
+}
+
+func TestPasswordResetToken(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("bcrypt_cost", "4")
+
+	t.Run("unknown e-mail", func(t *testing.T) {
+		mock := mockDatabase(t)
+		mock.ExpectQuery(`SELECT "id" FROM "users"`).
+			WithArgs("unknown@example.com").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		token, err := GeneratePasswordResetToken(ctx, "Unknown@Example.com")
+		if token != "" || err != nil {
+			t.Errorf("expected empty token and nil error, got %q %v", token, err)
+		}
+	})
+
+	t.Run("stores the token hash", func(t *testing.T) {
+		var stored driver.Value
+		mock := mockDatabase(t)
+		mock.ExpectQuery(`SELECT "id" FROM "users"`).
+			WithArgs("user@example.com").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectExec(`UPDATE "users" SET "reset_token"=\$1,"reset_token_expires_at"=\$2 WHERE "id"=\$3`).
+			WithArgs(captureArg{&stored}, sqlmock.AnyArg(), 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		token, err := GeneratePasswordResetToken(ctx, "user@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sum := sha256.Sum256([]byte(token))
+		if token == "" || stored != hex.EncodeToString(sum[:]) {
+			t.Errorf("expected the SHA-256 of token %q to be stored, got %v", token, stored)
+		}
+	})
+
+	expectTokenUser := func(mock sqlmock.Sqlmock, expiresAt time.Time) {
+		mock.ExpectQuery(`SELECT "id", "reset_token_expires_at" FROM "users"`).
+			WithArgs(hashResetToken("token")).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "reset_token_expires_at"}).AddRow(1, expiresAt))
+	}
+
+	t.Run("expired token", func(t *testing.T) {
+		mock := mockDatabase(t)
+		expectTokenUser(mock, time.Now().Add(-time.Minute))
+
+		if err := ResetPassword(ctx, "token", "newsecret"); !errors.Is(err, ErrResetTokenExpired) {
+			t.Errorf("expected ErrResetTokenExpired, got %v", err)
+		}
+	})
+
+	t.Run("used token", func(t *testing.T) {
+		mock := mockDatabase(t)
+		mock.ExpectQuery(`SELECT "id", "reset_token_expires_at" FROM "users"`).
+			WithArgs(hashResetToken("token")).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "reset_token_expires_at"}))
+
+		if err := ResetPassword(ctx, "token", "newsecret"); !errors.Is(err, ErrInvalidResetToken) {
+			t.Errorf("expected ErrInvalidResetToken, got %v", err)
+		}
+	})
+
+	t.Run("token used concurrently", func(t *testing.T) {
+		mock := mockDatabase(t)
+		expectTokenUser(mock, time.Now().Add(time.Minute))
+		mock.ExpectExec(`UPDATE "users" SET "password" = \$1, "refresh_token" = \$2, "reset_token" = \$3, "reset_token_expires_at" = \$4 WHERE \("users"\."id" = \$5\) AND \("users"\."reset_token" = \$6\)`).
+			WithArgs(sqlmock.AnyArg(), nil, nil, nil, 1, hashResetToken("token")).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		if err := ResetPassword(ctx, "token", "newsecret"); !errors.Is(err, ErrInvalidResetToken) {
+			t.Errorf("expected ErrInvalidResetToken, got %v", err)
+		}
+	})
+
+	t.Run("valid token", func(t *testing.T) {
+		mock := mockDatabase(t)
+		expectTokenUser(mock, time.Now().Add(time.Minute))
+		mock.ExpectExec(`UPDATE "users" SET "password" = \$1, "refresh_token" = \$2, "reset_token" = \$3, "reset_token_expires_at" = \$4`).
+			WithArgs(sqlmock.AnyArg(), nil, nil, nil, 1, hashResetToken("token")).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		if err := ResetPassword(ctx, "token", "newsecret"); err != nil {
+			t.Errorf("expected password reset, got %v", err)
+		}
+	})
+}
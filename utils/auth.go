@@ -1,9 +1,9 @@
 package utils
 
 import (
+	"context"
 	"log"
 	"net/http"
-	"os"
 	"strings"
 
 	"github.com/dgrijalva/jwt-go"
@@ -12,9 +12,19 @@ import (
 // Claims is a struct to represent Claims for JWF
 type Claims struct {
 	Email string `json:"email"`
+	Role  string `json:"role,omitempty"`
 	jwt.StandardClaims
 }
 
+// claimsContextKey is the key used to store the auth token Claims into request context
+type claimsContextKey struct{}
+
+// ClaimsFromRequest is a function to return the auth token Claims stored by IsAuthorizedMiddleware, or nil
+func ClaimsFromRequest(r *http.Request) *Claims {
+	claims, _ := r.Context().Value(claimsContextKey{}).(*Claims)
+	return claims
+}
+
 // IsAuthorizedMiddleware is a middleware function to validate JWF token from http request
 func IsAuthorizedMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -30,29 +40,50 @@ func IsAuthorizedMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		valid, msgError := ValidToken(extractedToken[1], "signin1")
-		if !valid || msgError != "" {
+		claims, msgError := ParseToken(extractedToken[1], "signin1")
+		if claims == nil || msgError != "" {
 			Respond(w, http.StatusUnauthorized, NewResponse(true, msgError, nil))
 			return
 		}
 
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsContextKey{}, claims)))
+	})
+}
+
+// IsAdminMiddleware is a middleware function to allow only auth tokens of admins, it must run after
+// IsAuthorizedMiddleware. The role is read from the token, so a demoted admin keeps access until the token expires
+func IsAdminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims := ClaimsFromRequest(r)
+		if claims == nil || claims.Role != "admin" {
+			Respond(w, http.StatusForbidden, NewResponse(true, "admin role required", nil))
+			return
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
 
 // GenerateNewJwt is a function to generate new JWF token in string format
 func GenerateNewJwt(claims Claims, typeKey string) string {
+	// Each token has a unique id (jti)
+	if claims.Id == "" {
+		id, err := randomHex(16)
+		if err != nil {
+			log.Println(err)
+			return ""
+		}
+		claims.Id = id
+	}
+
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
 	// There will be two types of JWF key, "signin1" for auth token and "signin2" for refres token
 	token.Header["type_key"] = typeKey
 
-	var signKey []byte = nil
-	if typeKey == "signin1" {
-		signKey = []byte(os.Getenv("jwtKey"))
-	} else {
-		signKey = []byte(os.Getenv("jwtRefresKey"))
-	}
+	// The kid identifies which signing key verifies the token after key rotations
+	kid, signKey := activeSigningKey(typeKey)
+	token.Header["kid"] = kid
 
 	tokenString, err := token.SignedString(signKey)
 	if err != nil {
@@ -65,26 +96,30 @@ func GenerateNewJwt(claims Claims, typeKey string) string {
 
 // ValidToken is a function to validate auth or refresh token
 func ValidToken(tokenString string, typeKey string) (bool, string) {
+	claims, msgError := ParseToken(tokenString, typeKey)
+	return claims != nil, msgError
+}
+
+// ParseToken is a function to validate auth or refresh token and return its Claims
+func ParseToken(tokenString string, typeKey string) (*Claims, string) {
 	claims := &Claims{}
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		// Valid if is auth or refresh token
-		if typeKey == "signin1" {
-			return []byte(os.Getenv("jwtKey")), nil
-		}
-		return []byte(os.Getenv("jwtRefresKey")), nil
+		// Valid if is auth or refresh token and pick the key by kid
+		kid, _ := token.Header["kid"].(string)
+		return verificationKey(typeKey, kid)
 	})
 
 	if err != nil {
 		if err == jwt.ErrSignatureInvalid {
-			return false, "token is malformed"
+			return nil, "token is malformed"
 		}
 
-		return false, "token is invalid"
+		return nil, "token is invalid"
 	}
 
 	if !token.Valid {
-		return false, "token is invalid"
+		return nil, "token is invalid"
 	}
 
-	return true, ""
+	return claims, ""
 }
@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsAdminMiddleware(t *testing.T) {
+	handler := IsAdminMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	cases := []struct {
+		name     string
+		claims   *Claims
+		expected int
+	}{
+		{"admin", &Claims{Email: "admin@example.com", Role: "admin"}, http.StatusNoContent},
+		{"user", &Claims{Email: "user@example.com", Role: "user"}, http.StatusForbidden},
+		{"no claims", nil, http.StatusForbidden},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/admin", nil)
+			if c.claims != nil {
+				r = r.WithContext(context.WithValue(r.Context(), claimsContextKey{}, c.claims))
+			}
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, r)
+
+			if w.Code != c.expected {
+				t.Errorf("expected status %d, got %d", c.expected, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// defaultKeyID is the kid of the key loaded from environment, also used for tokens signed without kid
+const defaultKeyID = "default"
+
+// envKeys is the environment variable holding the initial key of each token type
+var envKeys = map[string]string{
+	"signin1": "jwtKey",
+	"signin2": "jwtRefresKey",
+}
+
+// signingKey is a struct that stores a JWT signing key and when it stops being accepted
+type signingKey struct {
+	secret    []byte
+	retiresAt time.Time // zero while the key is still valid
+}
+
+// keyRing is a struct that stores all signing keys of a token type and which one signs new tokens
+type keyRing struct {
+	activeID string
+	keys     map[string]*signingKey
+}
+
+var (
+	keyRingsMut sync.Mutex
+	keyRings    = make(map[string]*keyRing)
+)
+
+// getKeyRing is a function to return the key ring of a token type, loading the environment key on first use.
+// keyRingsMut must be held by the caller
+func getKeyRing(typeKey string) *keyRing {
+	ring, ok := keyRings[typeKey]
+	if !ok {
+		ring = &keyRing{
+			activeID: defaultKeyID,
+			keys:     map[string]*signingKey{defaultKeyID: {secret: []byte(os.Getenv(envKeys[typeKey]))}},
+		}
+		keyRings[typeKey] = ring
+	}
+
+	return ring
+}
+
+// activeSigningKey is a function to return the kid and secret used to sign new tokens of a token type
+func activeSigningKey(typeKey string) (string, []byte) {
+	keyRingsMut.Lock()
+	defer keyRingsMut.Unlock()
+
+	ring := getKeyRing(typeKey)
+	return ring.activeID, ring.keys[ring.activeID].secret
+}
+
+// verificationKey is a function to return the secret identified by kid, if it was not retired yet
+func verificationKey(typeKey, kid string) ([]byte, error) {
+	keyRingsMut.Lock()
+	defer keyRingsMut.Unlock()
+
+	if kid == "" {
+		kid = defaultKeyID
+	}
+
+	ring := getKeyRing(typeKey)
+	key, ok := ring.keys[kid]
+	if !ok {
+		return nil, errors.New("unknown signing key")
+	}
+
+	if !key.retiresAt.IsZero() && time.Now().After(key.retiresAt) {
+		delete(ring.keys, kid)
+		return nil, errors.New("signing key was retired")
+	}
+
+	return key.secret, nil
+}
+
+// randomHex is a function to generate n cryptographically random bytes encoded in hex
+func randomHex(n int) (string, error) {
+	bytes := make([]byte, n)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(bytes), nil
+}
+
+// RotateSigningKey is a function to generate a new active key for a token type, returning its kid.
+// The previous active key keeps verifying tokens for ttl, so tokens already issued stay valid until they expire.
+// Rotated keys live only in the memory of this process: other instances of the API reject tokens signed with them,
+// and after a restart the environment key signs again and tokens signed with rotated keys are rejected.
+// Run a single instance when rotating keys, or rotate the environment keys and restart all instances instead
+func RotateSigningKey(typeKey string, ttl time.Duration) (string, error) {
+	if _, ok := envKeys[typeKey]; !ok {
+		return "", errors.New("unknown token type")
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	kid, err := randomHex(8)
+	if err != nil {
+		return "", err
+	}
+
+	keyRingsMut.Lock()
+	defer keyRingsMut.Unlock()
+
+	ring := getKeyRing(typeKey)
+	ring.keys[ring.activeID].retiresAt = time.Now().Add(ttl)
+	ring.keys[kid] = &signingKey{secret: secret}
+	ring.activeID = kid
+
+	return kid, nil
+}
@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// resetKeyRings is a function to discard rotated keys, so the test starts with the environment keys
+func resetKeyRings(t *testing.T) {
+	t.Setenv("jwtKey", "test-key")
+
+	keyRingsMut.Lock()
+	keyRings = make(map[string]*keyRing)
+	keyRingsMut.Unlock()
+}
+
+// newTestToken is a function to sign an auth token valid for an hour with the active key
+func newTestToken(t *testing.T) string {
+	t.Helper()
+
+	token := GenerateNewJwt(Claims{
+		Email:          "user@example.com",
+		StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(time.Hour).Unix()},
+	}, "signin1")
+	if token == "" {
+		t.Fatal("expected a signed token")
+	}
+
+	return token
+}
+
+// tokenKid is a function to return the kid header of a token without verifying it
+func tokenKid(t *testing.T, tokenString string) string {
+	t.Helper()
+
+	token, _, err := new(jwt.Parser).ParseUnverified(tokenString, &Claims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	return kid
+}
+
+func TestRotateSigningKey(t *testing.T) {
+	resetKeyRings(t)
+
+	oldToken := newTestToken(t)
+	if kid := tokenKid(t, oldToken); kid != defaultKeyID {
+		t.Fatalf("expected token signed by %s key, got %s", defaultKeyID, kid)
+	}
+
+	kid, err := RotateSigningKey("signin1", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// New key signs new tokens
+	newToken := newTestToken(t)
+	if tokenKid(t, newToken) != kid {
+		t.Errorf("expected new token signed by rotated key %s", kid)
+	}
+	if claims, msgError := ParseToken(newToken, "signin1"); claims == nil {
+		t.Errorf("expected new token to verify, got %q", msgError)
+	}
+
+	// Old key verifies until it is retired
+	if claims, msgError := ParseToken(oldToken, "signin1"); claims == nil {
+		t.Errorf("expected old token to verify before retirement, got %q", msgError)
+	}
+
+	keyRingsMut.Lock()
+	keyRings["signin1"].keys[defaultKeyID].retiresAt = time.Now().Add(-time.Second)
+	keyRingsMut.Unlock()
+
+	if claims, _ := ParseToken(oldToken, "signin1"); claims != nil {
+		t.Error("expected old token to be rejected after retirement")
+	}
+	if claims, msgError := ParseToken(newToken, "signin1"); claims == nil {
+		t.Errorf("expected new token to verify after retirement of old key, got %q", msgError)
+	}
+}
+
+func TestRotateSigningKeyUnknownType(t *testing.T) {
+	resetKeyRings(t)
+
+	if _, err := RotateSigningKey("signin3", time.Hour); err == nil {
+		t.Error("expected error for unknown token type")
+	}
+}
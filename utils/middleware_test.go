@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequireJSONMiddleware(t *testing.T) {
+	handler := RequireJSONMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	cases := []struct {
+		name        string
+		method      string
+		body        string
+		contentType string
+		expected    int
+	}{
+		{"json", http.MethodPost, `{}`, "application/json", http.StatusNoContent},
+		{"json with charset", http.MethodPut, `{}`, "application/json; charset=utf-8", http.StatusNoContent},
+		{"json patch", http.MethodPatch, `[]`, "application/json-patch+json", http.StatusNoContent},
+		{"wrong type", http.MethodPost, `name=user`, "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+		{"missing type", http.MethodPost, `{}`, "", http.StatusUnsupportedMediaType},
+		{"no body without type", http.MethodPost, ``, "", http.StatusNoContent},
+		{"get without type", http.MethodGet, ``, "", http.StatusNoContent},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest(c.method, "/api/users", strings.NewReader(c.body))
+			if c.contentType != "" {
+				r.Header.Set("Content-Type", c.contentType)
+			}
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, r)
+
+			if w.Code != c.expected {
+				t.Errorf("expected status %d, got %d", c.expected, w.Code)
+			}
+			if c.expected == http.StatusUnsupportedMediaType && !strings.Contains(w.Body.String(), `"error":true`) {
+				t.Errorf("expected JSON error body, got %s", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestTimeoutMiddleware(t *testing.T) {
+	t.Run("fast handler", func(t *testing.T) {
+		handler := TimeoutMiddleware(time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Handler", "done")
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("created"))
+		}))
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/users", nil))
+
+		if w.Code != http.StatusCreated || w.Body.String() != "created" || w.Header().Get("X-Handler") != "done" {
+			t.Errorf("expected handler response to be copied, got %d %q %v", w.Code, w.Body.String(), w.Header())
+		}
+	})
+
+	t.Run("slow handler", func(t *testing.T) {
+		cancelled := make(chan struct{})
+		handler := TimeoutMiddleware(10 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+				close(cancelled)
+			case <-time.After(time.Second):
+			}
+			w.Write([]byte("too late"))
+		}))
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/users", nil))
+
+		if w.Code != http.StatusServiceUnavailable {
+			t.Fatalf("expected status %d, got %d", http.StatusServiceUnavailable, w.Code)
+		}
+		var response Response
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("expected JSON body, got %q: %v", w.Body.String(), err)
+		}
+		if !response.Error || response.Message != "request timed out" {
+			t.Errorf("unexpected response %+v", response)
+		}
+		select {
+		case <-cancelled:
+		case <-time.After(time.Second):
+			t.Error("expected handler context to be cancelled")
+		}
+	})
+
+	t.Run("panicking handler", func(t *testing.T) {
+		handler := TimeoutMiddleware(time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		}))
+
+		defer func() {
+			if p := recover(); p != "boom" {
+				t.Errorf("expected panic to be re-raised, got %v", p)
+			}
+		}()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/users", nil))
+	})
+}